@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"gopkg.in/yaml.v3"
+)
+
+// baselineEntry is an accepted finding, identified by its object, rule and container. Messages are not
+// part of the identity, so all the findings of a rule in a container are accepted together
+type baselineEntry struct {
+	Kind string `yaml:"kind"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Name string `yaml:"name"`
+	Rule string `yaml:"rule"`
+	Container string `yaml:"container,omitempty"`
+}
+
+// baseline is the set of accepted findings, mapped to whether a finding of the current run matched them
+type baseline map[baselineEntry]bool
+
+func newBaselineEntry(r objectReport, f generator.Finding) (baselineEntry) {
+	return baselineEntry{Kind: r.Kind, Namespace: r.Namespace, Name: r.Name, Rule: f.Rule, Container: f.Container}
+}
+
+// readBaseline reads a baseline file written by -write-baseline
+func readBaseline(path string) (baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []baselineEntry
+	if err := yaml.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("Error reading baseline file %v:\n%s", path, err)
+	}
+	result := baseline{}
+	for _, e := range(entries) {
+		result[e] = false
+	}
+	return result, nil
+}
+
+// accepted returns true if the finding is in the baseline, and marks its entry as used
+func (b baseline) accepted(r objectReport, f generator.Finding) (bool) {
+	e := newBaselineEntry(r, f)
+	if _, ok := b[e]; !ok {
+		return false
+	}
+	b[e] = true
+	return true
+}
+
+// unused returns the number of entries that no finding matched, e.g. because they were fixed
+func (b baseline) unused() (int) {
+	count := 0
+	for _, used := range(b) {
+		if !used {
+			count++
+		}
+	}
+	return count
+}
+
+// writeBaseline writes the findings of every manifest that are not exempted, sorted and without duplicates
+func writeBaseline(w io.Writer, manifests []*manifest) (error) {
+	seen := map[baselineEntry]bool{}
+	entries := []baselineEntry{}
+	for _, m := range(manifests) {
+		for _, r := range(m.reports) {
+			for _, f := range(r.Findings) {
+				e := newBaselineEntry(r, f)
+				if f.Exempted || seen[e] {
+					continue
+				}
+				seen[e] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) (bool) {
+		a, b := entries[i], entries[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Container < b.Container
+	})
+
+	fmt.Fprintln(w, "# Accepted findings, written by manifest-hardening -check -write-baseline")
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	return encoder.Encode(entries)
+}
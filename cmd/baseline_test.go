@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// hardenedManifest hardens a manifest with a policy
+func hardenedManifest(t *testing.T, data string, pol string) (*manifest) {
+	documents, err := utils.DecodeDocuments(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector, err := policy.NewSelector(pol, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &manifest{documents: documents}
+	if err := m.harden(selector); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+const baselinePod = `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+  namespace: prod
+spec:
+  hostNetwork: true
+  containers:
+  - name: web
+    image: nginx
+    securityContext:
+      privileged: true
+`
+
+func TestBaselineRoundTrip(t *testing.T) {
+	m := hardenedManifest(t, baselinePod, "baseline")
+
+	var out bytes.Buffer
+	if err := writeBaseline(&out, []*manifest{m, m}); err != nil {
+		t.Fatalf("TestBaselineRoundTrip returned %v", err)
+	}
+	expected := `# Accepted findings, written by manifest-hardening -check -write-baseline
+- kind: Pod
+  namespace: prod
+  name: web
+  rule: host-network
+- kind: Pod
+  namespace: prod
+  name: web
+  rule: privileged
+  container: web
+`
+	if out.String() != expected {
+		t.Fatalf("TestBaselineRoundTrip wrote\n%v", out.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	os.WriteFile(path, out.Bytes(), 0644)
+	base, err := readBaseline(path)
+	if err != nil {
+		t.Fatalf("TestBaselineRoundTrip returned %v", err)
+	}
+	if !reflect.DeepEqual(base, baseline{
+		{Kind: "Pod", Namespace: "prod", Name: "web", Rule: "host-network"}: false,
+		{Kind: "Pod", Namespace: "prod", Name: "web", Rule: "privileged", Container: "web"}: false,
+	}) {
+		t.Fatalf("TestBaselineRoundTrip read %v", base)
+	}
+
+	os.WriteFile(path, []byte("- kind: [Pod\n"), 0644)
+	if _, err := readBaseline(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("TestBaselineRoundTrip returned %v for an invalid baseline", err)
+	}
+}
+
+func TestCheckManifestsBaseline(t *testing.T) {
+	m := hardenedManifest(t, baselinePod, "baseline")
+	accepted := func() (baseline) {
+		return baseline{
+			{Kind: "Pod", Namespace: "prod", Name: "web", Rule: "host-network"}: false,
+			{Kind: "Pod", Namespace: "prod", Name: "web", Rule: "privileged", Container: "web"}: false,
+		}
+	}
+
+	// without a baseline the findings fail the check, with it they are accepted
+	if status := checkManifests(&bytes.Buffer{}, []*manifest{m}, "", nil); status != 1 {
+		t.Fatalf("TestCheckManifestsBaseline returned %v without a baseline", status)
+	}
+	var out bytes.Buffer
+	if status := checkManifests(&out, []*manifest{m}, "", accepted()); status != 0 || strings.Contains(out.String(), "new finding") {
+		t.Fatalf("TestCheckManifestsBaseline returned %v with the baseline:\n%v", status, out.String())
+	}
+
+	// a finding of another rule is new
+	privileged := hardenedManifest(t, strings.Replace(baselinePod, "  hostNetwork: true\n", "  hostPID: true\n", 1), "baseline")
+	out.Reset()
+	if status := checkManifests(&out, []*manifest{privileged}, "", accepted()); status != 1 {
+		t.Fatalf("TestCheckManifestsBaseline returned %v for a new finding", status)
+	}
+	for _, expected := range([]string{"Pod web: new finding: hostPID", "1 baseline entries no longer match any finding", "1 objects have findings that are not in the baseline"}) {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("TestCheckManifestsBaseline did not print %q:\n%v", expected, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "new finding: Privileged") {
+		t.Fatalf("TestCheckManifestsBaseline reported an accepted finding:\n%v", out.String())
+	}
+}
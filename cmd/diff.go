@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+	"os"
+	"strings"
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	colorReset = "\x1b[0m"
+	colorBold = "\x1b[1m"
+	colorRed = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorCyan = "\x1b[36m"
+)
+
+// change is a document of the input and its hardened version. original is nil for generated objects
+type change struct {
+	original runtime.Object
+	hardened runtime.Object
+}
+
+func (c change) changed() (bool) {
+	return c.original == nil || !equality.Semantic.DeepEqual(c.original, c.hardened)
+}
+
+func anyChanged(changes []change) (bool) {
+	for _, c := range(changes) {
+		if c.changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// label returns the kind and name of the object
+func (c change) label() (string) {
+	name := ""
+	if metadata, err := meta.Accessor(c.hardened); err == nil {
+		name = metadata.GetName()
+	}
+	return fmt.Sprintf("%v %v", c.hardened.GetObjectKind().GroupVersionKind().Kind, name)
+}
+
+// unifiedDiff returns the unified diff between the serialized original and hardened objects,
+// or "" if hardening didn't change the object
+func unifiedDiff(file string, c change, color bool) (string, error) {
+	if !c.changed() {
+		return "", nil
+	}
+
+	from, to := c.label(), c.label() + " (hardened)"
+	if file != "" {
+		from, to = file + ": " + from, file + ": " + to
+	}
+
+	var original string
+	if c.original == nil {
+		from = "/dev/null"
+	} else {
+		var err error
+		if original, err = utils.ObjToString(c.original); err != nil {
+			return "", err
+		}
+	}
+	hardened, err := utils.ObjToString(c.hardened)
+	if err != nil {
+		return "", err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A: diffLines(original),
+		B: diffLines(hardened),
+		FromFile: from,
+		ToFile: to,
+		Context: 3,
+	})
+	if err != nil || !color {
+		return diff, err
+	}
+	return colorize(diff), nil
+}
+
+// diffLines splits a serialized object into lines, keeping their newline. Unlike difflib.SplitLines, it
+// doesn't add an empty line after the final newline, and the empty original of a new object has no lines
+func diffLines(s string) ([]string) {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// colorize adds ANSI colors to the headers, hunks and changed lines of a unified diff
+func colorize(diff string) (string) {
+	lines := strings.SplitAfter(diff, "\n")
+	for i, line := range(lines) {
+		switch {
+			case strings.HasPrefix(line, "---") || strings.HasPrefix(line, "+++"):
+				lines[i] = colorBold + strings.TrimSuffix(line, "\n") + colorReset + "\n"
+			case strings.HasPrefix(line, "@@"):
+				lines[i] = colorCyan + strings.TrimSuffix(line, "\n") + colorReset + "\n"
+			case strings.HasPrefix(line, "-"):
+				lines[i] = colorRed + strings.TrimSuffix(line, "\n") + colorReset + "\n"
+			case strings.HasPrefix(line, "+"):
+				lines[i] = colorGreen + strings.TrimSuffix(line, "\n") + colorReset + "\n"
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// useColor returns true if the diff is printed to a terminal and NO_COLOR is not set
+func useColor(mode string, out *os.File) (bool) {
+	switch mode {
+		case "always":
+			return true
+		case "never":
+			return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	stat, err := out.Stat()
+	return err == nil && (stat.Mode() & os.ModeCharDevice) != 0
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	privileged, unprivileged := true, false
+	original := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx", SecurityContext: &corev1.SecurityContext{Privileged: &privileged}}}},
+	}
+	hardened := original.DeepCopy()
+	hardened.Spec.Containers[0].SecurityContext.Privileged = &unprivileged
+
+	tests := []struct {
+		name string
+		file string
+		c change
+		color bool
+		expected string
+	}{
+		{
+			name: "changed",
+			file: "pod.yaml",
+			c: change{original: original, hardened: hardened},
+			expected: "--- pod.yaml: Pod web\n+++ pod.yaml: Pod web (hardened)\n@@ -9,5 +9,5 @@\n     name: web\n     resources: {}\n     securityContext:\n-      privileged: true\n+      privileged: false\n status: {}\n",
+		},
+		{
+			name: "colored",
+			file: "pod.yaml",
+			c: change{original: original, hardened: hardened},
+			color: true,
+			expected: colorBold + "--- pod.yaml: Pod web" + colorReset + "\n" + colorBold + "+++ pod.yaml: Pod web (hardened)" + colorReset + "\n" + colorCyan + "@@ -9,5 +9,5 @@" + colorReset + "\n     name: web\n     resources: {}\n     securityContext:\n" + colorRed + "-      privileged: true" + colorReset + "\n" + colorGreen + "+      privileged: false" + colorReset + "\n status: {}\n",
+		},
+		{
+			name: "generated",
+			c: change{hardened: hardened},
+			expected: "--- /dev/null\n+++ Pod web (hardened)\n@@ -0,0 +1,13 @@\n+apiVersion: v1\n+kind: Pod\n+metadata:\n+  creationTimestamp: null\n+  name: web\n+spec:\n+  containers:\n+  - image: nginx\n+    name: web\n+    resources: {}\n+    securityContext:\n+      privileged: false\n+status: {}\n",
+		},
+		{
+			name: "unchanged",
+			file: "pod.yaml",
+			c: change{original: original, hardened: original.DeepCopy()},
+			color: true,
+			expected: "",
+		},
+	}
+
+	for _, test := range(tests) {
+		diff, err := unifiedDiff(test.file, test.c, test.color)
+		if err != nil {
+			t.Fatalf("TestUnifiedDiff %v returned %v", test.name, err)
+		}
+		if diff != test.expected {
+			t.Fatalf("TestUnifiedDiff %v returned %q", test.name, diff)
+		}
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	// the output of the tests is a file, not a terminal
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	t.Setenv("NO_COLOR", "")
+	os.Unsetenv("NO_COLOR")
+	for mode, expected := range(map[string]bool{"auto": false, "always": true, "never": false}) {
+		if color := useColor(mode, out); color != expected {
+			t.Fatalf("TestUseColor returned %v for %v without a terminal", color, mode)
+		}
+	}
+
+	// NO_COLOR disables colors even when empty, but not when they are forced
+	t.Setenv("NO_COLOR", "")
+	if useColor("auto", os.Stdout) || !useColor("always", os.Stdout) {
+		t.Fatalf("TestUseColor ignored NO_COLOR")
+	}
+}
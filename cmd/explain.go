@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+// runExplain handles `explain [rule]...`, which describes the given rules, or lists every rule without arguments
+func runExplain(args []string) {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	pol := flags.String("policy", "", "policy whose custom rules are explained, either a path or the name of the policy {restricted, baseline}")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manifest-hardening explain [-policy <policy>] [rule]...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	pol_cfg := policy.Default()
+	if *pol != "" {
+		var err error
+		if pol_cfg, err = policy.Resolve(*pol); err != nil {
+			fmt.Printf("Error reading config file:\n%s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if flags.NArg() == 0 {
+		if err := listRules(os.Stdout, pol_cfg); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	for i, id := range(flags.Args()) {
+		e, err := generator.Explain(id, pol_cfg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if i > 0 {
+			fmt.Println("")
+		}
+		printExplanation(os.Stdout, e)
+	}
+}
+
+// listRules prints every rule with the PSS control it comes from
+func listRules(w io.Writer, pol policy.Policy) (error) {
+	rules, err := generator.EnabledRules(policy.Policy{CustomRules: pol.CustomRules})
+	if err != nil {
+		return err
+	}
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "RULE\tPSS CONTROL\tDESCRIPTION")
+	for _, r := range(rules) {
+		e, err := generator.Explain(r.ID(), pol)
+		if err != nil {
+			return err
+		}
+		control := e.Control
+		if control == "" {
+			control = "-"
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\n", e.Rule, control, e.Description)
+	}
+	return table.Flush()
+}
+
+func printExplanation(w io.Writer, e generator.Explanation) {
+	fmt.Fprintf(w, "%v: %v\n", e.Rule, e.Description)
+	if e.Control != "" {
+		fmt.Fprintf(w, "\nPSS control: %v\n", e.Control)
+	} else {
+		fmt.Fprintln(w, "\nPSS control: none, the rule is not part of the Pod Security Standards")
+	}
+	if e.Severity != "" {
+		fmt.Fprintf(w, "\nSeverity: %v\n", e.Severity)
+	}
+	if e.Risk != "" {
+		fmt.Fprintf(w, "\nRisk: %v\n", e.Risk)
+	}
+	if e.Remediation != "" {
+		fmt.Fprintf(w, "\nRemediation: %v\n", e.Remediation)
+	}
+	fmt.Fprintln(w, "\nExemption:")
+	for _, ex := range(e.Exemptions()) {
+		fmt.Fprintf(w, "  - %v\n", ex)
+	}
+}
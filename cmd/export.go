@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/export"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// runExport handles `export {vap, kyverno} -policy <policy>`, which compiles the policy into admission manifests
+func runExport(args []string) {
+	if len(args) == 0 || (args[0] != "vap" && args[0] != "kyverno") {
+		fmt.Println("Usage: manifest-hardening export {vap, kyverno} -policy {policyFile, policyName(restricted|baseline)} [-name name] [-output outputFile]")
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("export " + args[0], flag.ExitOnError)
+	pol := flags.String("policy", "", "either the path to the policy config file or the name of the policy {restricted, baseline}")
+	name := flags.String("name", "", "name of the generated resources. Defaults to manifest-hardening-<policy>")
+	outputFile := flags.String("output", "", "output manifest")
+	flags.Parse(args[1:])
+
+	if *pol == "" {
+		fmt.Println("Error: Missing required flag (policy)")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	pol_cfg, err := policy.Resolve(*pol)
+	if err != nil {
+		fmt.Printf("Error reading config file:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	if *name == "" {
+		*name = exportName(*pol)
+	}
+
+	checks := export.Checks(pol_cfg)
+	var objects []runtime.Object
+
+	switch args[0] {
+		case "vap":
+			vap, binding := export.ValidatingAdmissionPolicy(*name, checks)
+			objects = append(objects, vap, binding)
+
+		case "kyverno":
+			clusterPolicy, notMutated := export.KyvernoClusterPolicy(*name, pol_cfg)
+			objects = append(objects, clusterPolicy)
+			if len(notMutated) > 0 {
+				fmt.Fprintln(os.Stderr, "Warning: rules validated but not mutated:", strings.Join(notMutated, ", "))
+			}
+	}
+
+	for _, u := range(export.Unsupported(pol_cfg)) {
+		fmt.Fprintln(os.Stderr, "Warning:", u)
+	}
+
+	if *outputFile == "" {
+		for _, o := range(objects) {
+			objStr, err := utils.ObjToString(o)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println("---")
+			fmt.Println(objStr)
+		}
+	} else if err := utils.WriteObject(*outputFile, objects...); err != nil {
+		fmt.Printf("Error writing output file:\n%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// exportName derives a resource name from the policy name or path
+func exportName(pol string) (string) {
+	base := strings.TrimSuffix(filepath.Base(pol), filepath.Ext(pol))
+	return "manifest-hardening-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(base), "-"), "-")
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// inputFile is a manifest found in the input, with its path relative to the input directory or glob
+type inputFile struct {
+	path string
+	rel string
+}
+
+// isMultiInput returns true if the input is a directory or a glob pattern instead of a single file
+func isMultiInput(input string) (bool) {
+	if strings.ContainsAny(input, "*?[") {
+		return true
+	}
+	info, err := os.Stat(input)
+	return err == nil && info.IsDir()
+}
+
+// inputFiles returns the files of a directory, walked recursively, or of a glob pattern, sorted by path.
+// Files are kept if their name or relative path matches an include pattern and no exclude pattern.
+// Directories matching an exclude pattern are skipped
+func inputFiles(input string, include []string, exclude []string) ([]inputFile, error) {
+	var roots []string
+	base := input
+	if strings.ContainsAny(input, "*?[") {
+		matches, err := filepath.Glob(input)
+		if err != nil {
+			return nil, err
+		}
+		roots = matches
+		base = globBase(input)
+	} else {
+		roots = []string{input}
+	}
+
+	var result []inputFile
+	seen := map[string]bool{}
+	for _, root := range(roots) {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) (error) {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(base, p)
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != root && matchesAny(exclude, rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || seen[p] || !matchesAny(include, rel) || matchesAny(exclude, rel) {
+				return nil
+			}
+			seen[p] = true
+			result = append(result, inputFile{path: p, rel: rel})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(result, func(i, j int) (bool) {
+		return result[i].rel < result[j].rel
+	})
+	return result, nil
+}
+
+// globBase returns the directory of a glob pattern that comes before the first pattern element
+func globBase(pattern string) (string) {
+	dir := filepath.Dir(pattern)
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// matchesAny returns true if the base name or the slash separated relative path matches one of the patterns
+func matchesAny(patterns []string, rel string) (bool) {
+	slashed := filepath.ToSlash(rel)
+	for _, pattern := range(patterns) {
+		if ok, _ := path.Match(pattern, path.Base(slashed)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, slashed); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(value string) ([]string) {
+	var result []string
+	for _, item := range(strings.Split(value, ",")) {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// forEach calls fn with every index from 0 to n-1, running at most workers calls at a time
+func forEach(n int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range(jobs) {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// writeTree creates the files of a directory tree, given by their slash separated relative paths
+func writeTree(t *testing.T, dir string, files ...string) {
+	for _, f := range(files) {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("kind: Pod\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, "b.yaml", "a.yml", "notes.txt", "z/c.yaml", "sub/d.yaml", "sub/e.json", "sub/skip/f.yaml", "vendor/g.yaml")
+	yaml := []string{"*.yaml", "*.yml"}
+
+	tests := []struct {
+		name string
+		input string
+		include []string
+		exclude []string
+		glob bool
+		expected []string
+	}{
+		{name: "directory", input: dir, include: yaml, expected: []string{"a.yml", "b.yaml", "sub/d.yaml", "sub/skip/f.yaml", "vendor/g.yaml", "z/c.yaml"}},
+		{name: "include", input: dir, include: []string{"*.json", "notes.*"}, expected: []string{"notes.txt", "sub/e.json"}},
+		{name: "exclude directories", input: dir, include: yaml, exclude: []string{"vendor", "sub/skip"}, expected: []string{"a.yml", "b.yaml", "sub/d.yaml", "z/c.yaml"}},
+		{name: "exclude files", input: dir, include: yaml, exclude: []string{"*.yml", "sub/*.yaml"}, expected: []string{"b.yaml", "sub/skip/f.yaml", "vendor/g.yaml", "z/c.yaml"}},
+		{name: "subdirectory", input: filepath.Join(dir, "sub"), include: yaml, expected: []string{"d.yaml", "skip/f.yaml"}},
+		{name: "glob", glob: true, input: filepath.Join(dir, "*.yaml"), include: yaml, expected: []string{"b.yaml"}},
+		{name: "glob of directories", glob: true, input: filepath.Join(dir, "*", "*.yaml"), include: yaml, expected: []string{"sub/d.yaml", "vendor/g.yaml", "z/c.yaml"}},
+		{name: "glob of walked directories", glob: true, input: filepath.Join(dir, "s*"), include: yaml, expected: []string{"sub/d.yaml", "sub/skip/f.yaml"}},
+		{name: "glob without matches", glob: true, input: filepath.Join(dir, "*.xml"), include: yaml, expected: nil},
+	}
+
+	for _, test := range(tests) {
+		files, err := inputFiles(test.input, test.include, test.exclude)
+		if err != nil {
+			t.Fatalf("TestInputFiles %v returned %v", test.name, err)
+		}
+		// the relative paths of a glob are relative to the directory before its first pattern element
+		base := test.input
+		if test.glob {
+			base = dir
+		}
+		var rels []string
+		for _, f := range(files) {
+			rels = append(rels, filepath.ToSlash(f.rel))
+			if f.path != filepath.Join(base, f.rel) {
+				t.Fatalf("TestInputFiles %v returned the path %v for %v", test.name, f.path, f.rel)
+			}
+		}
+		if !reflect.DeepEqual(rels, test.expected) {
+			t.Fatalf("TestInputFiles %v returned %v, expected %v", test.name, rels, test.expected)
+		}
+	}
+}
+
+func TestInputFilesErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, input := range([]string{filepath.Join(dir, "missing"), filepath.Join(dir, "[")}) {
+		if _, err := inputFiles(input, []string{"*.yaml"}, nil); err == nil {
+			t.Fatalf("TestInputFilesErrors did not return an error for %v", input)
+		}
+	}
+}
+
+func TestGlobBase(t *testing.T) {
+	tests := map[string]string{
+		"manifests/*.yaml": "manifests",
+		"manifests/*/deploy/*.yaml": "manifests",
+		"manifests/app/[ab]/*.yaml": "manifests/app",
+		"*.yaml": ".",
+		"/abs/dir/pod-?.yaml": "/abs/dir",
+	}
+	for pattern, expected := range(tests) {
+		if base := filepath.ToSlash(globBase(filepath.FromSlash(pattern))); base != expected {
+			t.Fatalf("TestGlobBase returned %v for %v, expected %v", base, pattern, expected)
+		}
+	}
+}
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel string
+		expected bool
+	}{
+		{patterns: []string{"*.yaml"}, rel: "a/b/pod.yaml", expected: true},
+		{patterns: []string{"a/*/pod.yaml"}, rel: "a/b/pod.yaml", expected: true},
+		{patterns: []string{"a/*"}, rel: "a/b/pod.yaml", expected: false},
+		{patterns: []string{"*.yml", "vendor"}, rel: "vendor", expected: true},
+		{patterns: nil, rel: "pod.yaml", expected: false},
+	}
+	for _, test := range(tests) {
+		if matches := matchesAny(test.patterns, filepath.FromSlash(test.rel)); matches != test.expected {
+			t.Fatalf("TestMatchesAny returned %v for %v and %v", matches, test.rel, test.patterns)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	for _, workers := range([]int{0, 1, 3, 20}) {
+		n := 10
+		calls := make([]int, n)
+		errs := make([]error, n)
+		var mu sync.Mutex
+		running, maxRunning := 0, 0
+		forEach(n, workers, func(i int) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			calls[i]++
+			mu.Unlock()
+			if i % 4 == 1 {
+				errs[i] = fmt.Errorf("error %v", i)
+			}
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+
+		limit := workers
+		if limit < 1 {
+			limit = 1
+		}
+		if maxRunning > limit {
+			t.Fatalf("TestForEach ran %v calls at a time with %v workers", maxRunning, workers)
+		}
+		// every call stores its error at its index, so errors are reported in input order
+		for i, err := range(errs) {
+			if calls[i] != 1 {
+				t.Fatalf("TestForEach called index %v %v times with %v workers", i, calls[i], workers)
+			}
+			if i % 4 == 1 && (err == nil || err.Error() != fmt.Sprintf("error %v", i)) || i % 4 != 1 && err != nil {
+				t.Fatalf("TestForEach returned %v at index %v with %v workers", err, i, workers)
+			}
+		}
+	}
+}
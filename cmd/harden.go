@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+	"io"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// objectReport holds the findings of a hardened object
+type objectReport struct {
+	File string `yaml:"file,omitempty"`
+	Kind string `yaml:"kind"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Name string `yaml:"name"`
+	Policy string `yaml:"policy"`
+	Findings []generator.Finding `yaml:"findings"`
+	policy policy.Policy // policy of the object, used to explain its custom rules
+}
+
+// manifest is a file of the input, or stdin, with its documents and the result of hardening them
+type manifest struct {
+	inputFile
+	format string // format of the input, yaml or json
+	documents []utils.Document
+	objects []runtime.Object
+	changes []change
+	reports []objectReport
+	verify bool // harden every object again and fail if it is not a no-op
+	err error
+}
+
+// registerNamespaces adds the Pod Security Admission labels of the Namespace documents to the selector
+func registerNamespaces(selector *policy.Selector, documents []utils.Document) (error) {
+	for _, document := range(documents) {
+		if ns, ok := document.Object.(*corev1.Namespace); ok {
+			if err := selector.AddNamespace(ns); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// harden hardens the supported documents of the manifest and generates their service accounts.
+// Other documents are kept unchanged
+func (m *manifest) harden(selector *policy.Selector) (error) {
+	m.objects = []runtime.Object{}
+
+	for _, document := range(m.documents) {
+		metadata, _ := meta.Accessor(document.Object)
+		pol_cfg, source, err := selector.Select(metadata.GetNamespace())
+
+		// custom resources are only hardened if the policy of their namespace lists them in Workloads
+		if !generator.SupportedKind(document.GVK) && (err != nil || !generator.Supports(document.GVK, pol_cfg)) {
+			m.objects = append(m.objects, document.Object)
+			m.changes = append(m.changes, change{original: document.Object, hardened: document.Object})
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("Error selecting the policy of %v %v:\n%s", document.GVK.Kind, metadata.GetName(), err)
+		}
+
+		newObject, output, err := generator.GenerateHardenedObject(document.Object, document.GVK, pol_cfg)
+
+		if err != nil {
+			return err
+		}
+		if m.verify {
+			if err := generator.VerifyIdempotent(newObject, document.GVK, pol_cfg); err != nil {
+				return fmt.Errorf("%v %v: %s", document.GVK.Kind, metadata.GetName(), err)
+			}
+		}
+
+		m.objects = append(m.objects, newObject)
+		m.changes = append(m.changes, change{original: document.Object, hardened: newObject})
+		if serviceAccount := generator.GenerateServiceAccount(document.Object, document.GVK, pol_cfg); serviceAccount != nil {
+			m.objects = append(m.objects, serviceAccount)
+			m.changes = append(m.changes, change{hardened: serviceAccount})
+		}
+
+		m.reports = append(m.reports, objectReport{
+			File: m.rel,
+			Kind: document.GVK.Kind,
+			Namespace: metadata.GetNamespace(),
+			Name: metadata.GetName(),
+			Policy: source,
+			Findings: output,
+			policy: pol_cfg,
+		})
+	}
+	return nil
+}
+
+// changed returns true if hardening modified a document or generated a service account
+func (m *manifest) changed() (bool) {
+	return anyChanged(m.changes)
+}
+
+// printDiff prints the unified diff of every document changed by hardening
+func (m *manifest) printDiff(w io.Writer, color bool) (error) {
+	for _, c := range(m.changes) {
+		diff, err := unifiedDiff(m.rel, c, color)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(w, diff)
+	}
+	return nil
+}
+
+// printFindings prints the findings of every hardened object of the manifest. With explain, every finding
+// that is not exempted is followed by the PSS control of its rule, the risk and how to exempt the object
+func (m *manifest) printFindings(w io.Writer, explain bool) {
+	for _, r := range(m.reports) {
+		if r.File != "" {
+			fmt.Fprintf(w, "%v: ", r.File)
+		}
+		fmt.Fprintf(w, "%v %v, policy %v:\n", r.Kind, r.Name, r.Policy)
+		for _, f := range(r.Findings) {
+			if f.Severity != "" {
+				fmt.Fprintf(w, "[%v] ", f.Severity)
+			}
+			fmt.Fprintln(w, f.Message)
+			if explain && !f.Exempted {
+				printFindingExplanation(w, f, r.policy)
+			}
+		}
+		fmt.Fprintln(w, "")
+	}
+}
+
+func printFindingExplanation(w io.Writer, f generator.Finding, pol policy.Policy) {
+	e, err := generator.Explain(f.Rule, pol)
+	if err != nil {
+		return
+	}
+	if e.Control != "" {
+		fmt.Fprintf(w, "    PSS control: %v\n", e.Control)
+	}
+	if e.Risk != "" {
+		fmt.Fprintf(w, "    Risk: %v\n", e.Risk)
+	}
+	fmt.Fprintf(w, "    Exemption: annotation %v, see `manifest-hardening explain %v`\n", e.Annotation(f.Container), f.Rule)
+}
+
+// writeReport writes the findings of every manifest, in input order
+func writeReport(w io.Writer, manifests []*manifest) (error) {
+	reports := []objectReport{}
+	for _, m := range(manifests) {
+		reports = append(reports, m.reports...)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	return encoder.Encode(reports)
+}
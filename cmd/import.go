@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/importer"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runImport handles `import [-input file] [-output file]`, which translates PodSecurityPolicies and
+// Gatekeeper K8sPSP* constraints into a policy file
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	inputFile := flags.String("input", "", "PodSecurityPolicy or K8sPSP* constraints manifest. Read from stdin if not set")
+	outputFile := flags.String("output", "", "output policy file")
+	flags.Parse(args)
+
+	var input io.Reader = os.Stdin
+	if *inputFile != "" {
+		file, err := os.Open(*inputFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	pol_cfg, unsupported, err := importer.Import(input)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	if len(unsupported) > 0 {
+		out.WriteString("# The following settings can't be represented in the policy:\n")
+		for _, u := range(unsupported) {
+			fmt.Fprintln(os.Stderr, "Warning:", u)
+			out.WriteString("# - " + u + "\n")
+		}
+	}
+	if err := encodePolicy(&out, pol_cfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *outputFile == "" {
+		fmt.Print(out.String())
+	} else if err := os.WriteFile(*outputFile, out.Bytes(), 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/krm"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runKRM handles `krm`, which runs the hardener as a KRM function (kustomize, kpt): it reads a ResourceList
+// from stdin and writes the hardened ResourceList to stdout
+func runKRM(args []string) {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: manifest-hardening krm < resourceList.yaml")
+		os.Exit(1)
+	}
+
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	output, err := krm.Run(input)
+	if output != nil {
+		os.Stdout.Write(output)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
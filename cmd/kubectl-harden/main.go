@@ -0,0 +1,10 @@
+package main
+
+import (
+	"edurra/manifest-hardening/cmd"
+)
+
+// kubectl-harden is installed in the PATH as a kubectl plugin, `kubectl harden`
+func main() {
+	cmd.RunKubectl()
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/kubectl"
+	"edurra/manifest-hardening/internal/policy"
+	"fmt"
+	"os"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// RunKubectl is the entrypoint of the kubectl plugin, `kubectl harden deploy/web -n prod --policy restricted`.
+// It hardens live resources and prints the diff, and applies the hardened version with --apply
+func RunKubectl() {
+	flags := pflag.NewFlagSet("kubectl-harden", pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: kubectl harden (TYPE/NAME | TYPE NAME...) [--policy <policy>] [--apply] [flags]")
+		flags.PrintDefaults()
+	}
+	configFlags := genericclioptions.NewConfigFlags(true)
+	configFlags.AddFlags(flags)
+	pol := flags.String("policy", "", "either the path to the policy config file or the name of the policy {restricted, baseline}")
+	namespacePolicies := flags.String("namespace-policies", "", "path to the file mapping namespaces to policies")
+	apply := flags.Bool("apply", false, "apply the hardened resources with server-side apply")
+	fieldManager := flags.String("field-manager", kubectl.DefaultFieldManager, "name of the manager of the applied fields")
+	forceConflicts := flags.Bool("force-conflicts", false, "with --apply, take over the fields owned by other managers")
+	verbose := flags.Bool("verbose", false, "print the changes made to every resource")
+	color := flags.String("color", "auto", "colorize the diff {auto, always, never}")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no resource given")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	namespace, _, err := configFlags.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// the Pod Security Admission labels of the live namespaces take precedence over --policy, like Namespace documents
+	selector, err := policy.NewSelector(*pol, *namespacePolicies)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading namespace mapping file:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	newBuilder := func() (*resource.Builder) {
+		return resource.NewBuilder(configFlags)
+	}
+	objects, err := kubectl.Harden(newBuilder, selector, namespace, flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, w := range(selector.Warnings()) {
+		fmt.Fprintln(os.Stderr, "Warning:", w)
+	}
+
+	colorize := useColor(*color, os.Stdout)
+	for _, o := range(objects) {
+		name := o.Info.ObjectName()
+		if !o.Changed() {
+			fmt.Fprintf(os.Stderr, "%v is already hardened\n", name)
+			continue
+		}
+
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "%v, policy %v:\n", name, o.Policy)
+			for _, f := range(o.Findings) {
+				fmt.Fprintln(os.Stderr, f.Message)
+			}
+			fmt.Fprintln(os.Stderr, "")
+		}
+
+		changes := []change{{original: o.Original, hardened: o.Hardened}}
+		if o.ServiceAccount != nil {
+			changes = append(changes, change{hardened: o.ServiceAccount})
+		}
+		for _, c := range(changes) {
+			diff, err := unifiedDiff("", c, colorize)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Print(diff)
+		}
+
+		if !*apply {
+			continue
+		}
+		applied, err := kubectl.Apply(newBuilder, o, *fieldManager, *forceConflicts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, info := range(applied) {
+			fmt.Fprintf(os.Stderr, "%v serverside-applied\n", info.ObjectName())
+		}
+	}
+}
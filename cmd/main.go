@@ -1,150 +1,380 @@
 package cmd
 
 import (
+	"bytes"
+	"edurra/manifest-hardening/internal/generator"
 	"edurra/manifest-hardening/internal/utils"
 	"edurra/manifest-hardening/internal/policy"
-	"edurra/manifest-hardening/internal/generator"
 	"fmt"
 	"flag"
+	"io"
 	"os"
-	"github.com/spf13/viper"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"path/filepath"
+	goruntime "runtime"
+	"strings"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func Run() {
-	var obj runtime.Object
-	var gKV *schema.GroupVersionKind
-	var err error
-	var pol_cfg policy.Policy
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		runPolicy(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "post-render" {
+		runPostRender(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "krm" {
+		runKRM(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "explain" {
+		runExplain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		runTest(os.Args[2:])
+		return
+	}
 
-	inputFile := flag.String("input", "", "input manifest")
-	outputFile := flag.String("output", "", "output manifest")
+	inputPath := flag.String("input", "", "input manifest, directory or glob pattern. Read from stdin if not set")
+	outputFile := flag.String("output", "", "output manifest, or output directory if the input is a directory or glob pattern")
 	pol := flag.String("policy", "", "either the path to the policy config file or the name of the policy {restricted, baseline}")
+	namespacePolicies := flag.String("namespace-policies", "", "path to the file mapping namespaces to policies")
 	verbose := flag.Bool("verbose", false, "print the changes made to the manifest")
+	explain := flag.Bool("explain", false, "print the changes made to the manifest, with the PSS control, the risk and the exemptions of their rules")
+	include := flag.String("include", "*.yaml,*.yml", "comma separated patterns of the files read from an input directory")
+	exclude := flag.String("exclude", "", "comma separated patterns of the files and directories skipped in an input directory")
+	workers := flag.Int("workers", goruntime.NumCPU(), "number of files hardened concurrently")
+	inPlace := flag.Bool("in-place", false, "overwrite every input file with its hardened version. Files that need no changes are left untouched")
+	backup := flag.Bool("backup", false, "with -in-place, keep a copy of every modified file in <file>.bak")
+	reportFile := flag.String("report", "", "write the findings of every object to this file")
+	diff := flag.Bool("diff", false, "print a unified diff of the changes made to every document instead of the hardened manifests")
+	color := flag.String("color", "auto", "colorize the diff {auto, always, never}")
+	check := flag.Bool("check", false, "don't write anything, exit with status 1 if any object needs hardening or has findings")
+	baselineFile := flag.String("baseline", "", "with -check, only fail on the findings that are not in this baseline file")
+	writeBaselineFile := flag.String("write-baseline", "", "with -check, write the current findings to this baseline file and exit with status 0")
+	failOn := flag.String("fail-on", "", "with -check, only exit with status 1 if a finding has this severity or a higher one {low, medium, high, critical}")
+	verifyIdempotent := flag.Bool("verify-idempotent", false, "harden every hardened object again and fail if the second pass changes it or reports findings")
+	inputFormat := flag.String("input-format", utils.FormatAuto, "format of the input manifests {auto, yaml, json}")
+	outputFormatFlag := flag.String("output-format", utils.FormatAuto, "format of the output manifests {auto, yaml, json}. With auto, the extension of the output file or the format of the input is used")
 
 	flag.Parse()
 
-	if *inputFile == "" {
-		obj, gKV, err = utils.ReadFromPipe()
-
-		if err != nil {
-			fmt.Println(err)
+	for _, f := range([]string{*inputFormat, *outputFormatFlag}) {
+		if !utils.ContainsValue(utils.Formats, f) {
+			fmt.Printf("Error: invalid format %q\n", f)
 			flag.Usage()
 			os.Exit(1)
 		}
-
-	} else {
-		obj, gKV, err = utils.ReadObject(*inputFile)
 	}
 
-	if *pol == "restricted" || *pol == "baseline" {
+	if *failOn != "" && (!*check || !utils.ContainsValue(policy.SeverityLevels, *failOn)) {
+		fmt.Printf("Error: -fail-on requires -check and a severity {%v}\n", strings.Join(policy.SeverityLevels, ", "))
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		pol_cfg = getPolicy(*pol)
+	if (*baselineFile != "" || *writeBaselineFile != "") && !*check {
+		fmt.Println("Error: -baseline and -write-baseline require -check")
+		flag.Usage()
+		os.Exit(1)
+	}
+	var base baseline
+	if *baselineFile != "" {
+		var err error
+		if base, err = readBaseline(*baselineFile); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-	} else if *pol != ""{
+	multi := isMultiInput(*inputPath)
+	var manifests []*manifest
 
-		viper.SetConfigName("config")
-		viper.SetConfigFile(*pol)
-		viper.SetConfigType("yml")
-	
-		if err := viper.ReadInConfig(); err != nil {
-			fmt.Printf("Error reading config file, %s", err)
+	if *inputPath == "" {
+		if *inPlace {
+			fmt.Println("Error: -in-place requires -input")
 			os.Exit(1)
 		}
-	
-	
-		viper.SetDefault("HostPID", true)
-		viper.SetDefault("HostNetwork", true)
-		viper.SetDefault("HostIPC", true)
-		viper.SetDefault("Privileged", true)
-		viper.SetDefault("HostProcess", true)
-		viper.SetDefault("CapabilitiesAdd", []string{"ALL"})
-		viper.SetDefault("CapabilitiesDrop", []string{})
-		viper.SetDefault("ProcMount", "")
-		viper.SetDefault("Seccomp", []string{"Undefined"})
-		viper.SetDefault("AllowedVolumes", []string{"*"})
-		viper.SetDefault("DisAllowedVolumes", []string{})
-		viper.SetDefault("AllowPrivilegeEscalation", true)
-		viper.SetDefault("RunAsNonRoot", false)
-		viper.SetDefault("RunAsUser", false)
-	
-		if err := viper.Unmarshal(&pol_cfg); err != nil {
-			fmt.Println("Error unmarshaling config file:", err)
-			return
-		}
-	
-	
+		documents, format, err := utils.ReadFromPipe(*inputFormat)
+
 		if err != nil {
 			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
 		}
+		manifests = []*manifest{{documents: documents, format: format}}
 
 	} else {
+		files := []inputFile{{path: *inputPath}}
+		if multi {
+			var err error
+			if files, err = inputFiles(*inputPath, splitList(*include), splitList(*exclude)); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		for _, f := range(files) {
+			manifests = append(manifests, &manifest{inputFile: f})
+		}
+
+		forEach(len(manifests), *workers, func(i int) {
+			manifests[i].documents, manifests[i].format, manifests[i].err = utils.ReadObjectsFormat(manifests[i].path, *inputFormat)
+		})
+		for _, m := range(manifests) {
+			if m.err != nil {
+				fmt.Printf("%v: %v\n", m.path, m.err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	var documents []utils.Document
+	for _, m := range(manifests) {
+		documents = append(documents, m.documents...)
+	}
+
+	if *pol == "" && *namespacePolicies == "" && !hasEnforceLabels(documents) {
 		fmt.Println("Error: Missing required flag (policy)")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	
+	selector, err := policy.NewSelector(*pol, *namespacePolicies)
+
+	if err != nil {
+		fmt.Printf("Error reading namespace mapping file:\n%s\n", err)
+		os.Exit(1)
+	}
 
-	newObject, output, err := generator.GenerateHardenedObject(obj, gKV, pol_cfg)
+	// Namespaces apply to the objects of every file, so they are registered before hardening
+	if err := registerNamespaces(selector, documents); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, w := range(selector.Warnings()) {
+		fmt.Fprintln(os.Stderr, "Warning:", w)
+	}
 
-	if err == nil {
+	forEach(len(manifests), *workers, func(i int) {
+		manifests[i].verify = *verifyIdempotent
+		manifests[i].err = manifests[i].harden(selector)
+	})
 
-		if *verbose {
-			for _, o := range(output) {
-				fmt.Println(o)
+	for _, m := range(manifests) {
+		if m.err != nil {
+			if m.rel != "" {
+				fmt.Printf("%v: ", m.rel)
 			}
-			fmt.Println("")
+			fmt.Println(m.err)
+			os.Exit(1)
+		}
+		if *verbose || *explain {
+			m.printFindings(os.Stdout, *explain)
 		}
+		if *diff {
+			if err := m.printDiff(os.Stdout, useColor(*color, os.Stdout)); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+	}
 
-		if *outputFile == "" {
-			objStr, _ := utils.ObjToString(newObject)
-			fmt.Println("---")
-			fmt.Println(objStr)
-		} else {
-			utils.WriteObject(*outputFile, newObject)
+	if *reportFile != "" {
+		if err := writeFile(*reportFile, func(w io.Writer) (error) { return writeReport(w, manifests) }); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-		
-	} else {
-		fmt.Println(err)
 	}
 
+	if *check && *writeBaselineFile != "" {
+		if err := writeFile(*writeBaselineFile, func(w io.Writer) (error) { return writeBaseline(w, manifests) }); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		checkManifests(os.Stdout, manifests, *failOn, base)
+		os.Exit(0)
+	}
+	if *check {
+		os.Exit(checkManifests(os.Stdout, manifests, *failOn, base))
+	}
+
+	var console []runtime.Object
+	consoleFormat := utils.FormatYAML
+
+	for _, m := range(manifests) {
+		var err error
+		format := outputFormat(*outputFormatFlag, *outputFile, m.format)
+		switch {
+			case *inPlace:
+				if m.changed() {
+					var buf bytes.Buffer
+					if err = utils.EncodeObjects(&buf, format, m.objects...); err == nil {
+						err = utils.ReplaceFile(m.path, buf.Bytes(), *backup)
+					}
+				}
+			case *outputFile != "" && multi:
+				target := filepath.Join(*outputFile, m.rel)
+				if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+					err = utils.WriteObjectFormat(target, format, m.objects...)
+				}
+			case *outputFile != "":
+				err = utils.WriteObjectFormat(*outputFile, format, m.objects...)
+			case *diff:
+				// the diff replaces the hardened manifests on the console
+			case format == utils.FormatJSON:
+				// JSON objects are printed as a single List once every manifest is processed
+				console = append(console, m.objects...)
+				consoleFormat = format
+			default:
+				for _, o := range(m.objects) {
+					objStr, _ := utils.ObjToString(o)
+					fmt.Println("---")
+					if multi {
+						fmt.Printf("# Source: %v\n", m.rel)
+					}
+					fmt.Println(objStr)
+				}
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if len(console) > 0 {
+		if err := utils.EncodeObjects(os.Stdout, consoleFormat, console...); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+}
+
+// outputFormat returns the format of the output. With auto, it is taken from the extension of the output file
+// or, if it has no known extension, from the format of the input
+func outputFormat(format string, outputFile string, inputFormat string) (string) {
+	if format != utils.FormatAuto {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(outputFile)) {
+		case ".json":
+			return utils.FormatJSON
+		case ".yaml", ".yml":
+			return utils.FormatYAML
+	}
+	if inputFormat == "" {
+		return utils.FormatYAML
+	}
+	return inputFormat
+}
+
+// checkManifests prints the objects that need hardening and returns the exit status of check mode
+func checkManifests(w io.Writer, manifests []*manifest, failOn string, base baseline) (int) {
+	count, blocking := 0, 0
+	for _, m := range(manifests) {
+		prefix := ""
+		if m.rel != "" {
+			prefix = m.rel + ": "
+		}
+		for _, c := range(m.changes) {
+			switch {
+				case c.original == nil:
+					fmt.Fprintf(w, "%v%v would be generated\n", prefix, c.label())
+				case c.changed():
+					fmt.Fprintf(w, "%v%v needs hardening\n", prefix, c.label())
+				default:
+					continue
+			}
+			count++
+		}
+		for _, r := range(m.reports) {
+			if findings := blockingFindings(r, failOn, base); len(findings) > 0 {
+				blocking++
+				if base != nil {
+					for _, f := range(findings) {
+						fmt.Fprintf(w, "%v%v %v: new finding: %v\n", prefix, r.Kind, r.Name, f.Message)
+					}
+				}
+			}
+		}
+	}
+	if count > 0 {
+		fmt.Fprintf(w, "%d objects need hardening\n", count)
+	}
+	if base != nil && base.unused() > 0 {
+		fmt.Fprintf(w, "%d baseline entries no longer match any finding and can be removed by writing the baseline again\n", base.unused())
+	}
+
+	// without a threshold or a baseline, objects fail the check if they change or have findings, e.g. of custom
+	// rules without a patch. Otherwise objects whose findings are below the threshold or in the baseline are
+	// still listed, but don't fail the check
+	if blocking > 0 {
+		what := "findings"
+		if failOn != "" {
+			what += fmt.Sprintf(" of severity %v or higher", failOn)
+		}
+		if base != nil {
+			what += " that are not in the baseline"
+		}
+		fmt.Fprintf(w, "%d objects have %v\n", blocking, what)
+		return 1
+	}
+	if failOn == "" && base == nil && count > 0 {
+		return 1
+	}
+	return 0
+}
+
+// blockingFindings returns the findings of the object that fail the check: the ones that are not exempted,
+// have the severity of the threshold or a higher one, if set, and are not in the baseline, if set
+func blockingFindings(r objectReport, threshold string, base baseline) ([]generator.Finding) {
+	var result []generator.Finding
+	for _, f := range(r.Findings) {
+		if f.Exempted {
+			continue
+		}
+		// every finding is matched against the baseline, to report the unused entries
+		accepted := base != nil && base.accepted(r, f)
+		if accepted || (threshold != "" && !policy.SeverityAtLeast(f.Severity, threshold)) {
+			continue
+		}
+		result = append(result, f)
+	}
+	return result
 }
 
-func getPolicy(pol string) (policy.Policy) {
-	policies := map[string]policy.Policy{
-		"baseline": policy.Policy{
-			HostPID: false,
-			HostNetwork: false,
-			HostIPC: false,
-			Privileged: false,
-			HostProcess: false,
-			CapabilitiesAdd: []string{"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT"},
-			ProcMount: "Default",
-			Seccomp: []string{"RuntimeDefault", "Localhost", "Undefined"},
-			DisallowedVolumes: []string{"HostPath"},
-			AllowedVolumes: []string{"*"},
-			AllowPrivilegeEscalation: true,
-			RunAsNonRoot: false,
-			RunAsUser: false,
-		},
-		"restricted": policy.Policy{
-			HostPID: false,
-			HostNetwork: false,
-			HostIPC: false,
-			Privileged: false,
-			HostProcess: false,
-			CapabilitiesAdd: []string{"NET_BIND_SERVICE"},
-			CapabilitiesDrop: []string{"ALL"},
-			ProcMount: "Default",
-			Seccomp: []string{"RuntimeDefault", "Localhost"},
-			DisallowedVolumes: []string{"HostPath"},
-			AllowedVolumes: []string{"ConfigMap", "CSI", "DownwardAPI", "EmptyDir", "Ephemeral", "PersistentVolumeClaim", "Projected", "Secret"},
-			AllowPrivilegeEscalation: false,
-			RunAsNonRoot: true,
-			RunAsUser: true,
-		},
-	}
-	return policies[pol]
+// writeFile creates the file and writes its content with the given function
+func writeFile(path string, write func(w io.Writer) (error)) (error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// hasEnforceLabels returns true if any Namespace of the input sets the Pod Security Admission enforce label
+func hasEnforceLabels(documents []utils.Document) (bool) {
+	for _, document := range(documents) {
+		if ns, ok := document.Object.(*corev1.Namespace); ok {
+			if _, ok := ns.Labels[policy.EnforceLabel]; ok {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckManifestsCheckOnlyFinding(t *testing.T) {
+	dir := t.TempDir()
+	policyPath := filepath.Join(dir, "policy.yaml")
+	os.WriteFile(policyPath, []byte("CustomRules:\n  - ID: registry\n    Scope: Container\n    Expression: container.image.startsWith('registry.corp/')\n    Severity: high\n"), 0644)
+	documents, err := utils.DecodeDocument([]byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\nspec:\n  containers:\n  - name: web\n    image: nginx\n"))
+	if err != nil {
+		t.Fatalf("TestCheckManifestsCheckOnlyFinding returned %v", err)
+	}
+	selector, err := policy.NewSelector(policyPath, "")
+	if err != nil {
+		t.Fatalf("TestCheckManifestsCheckOnlyFinding returned %v", err)
+	}
+	m := &manifest{documents: documents}
+	if err := m.harden(selector); err != nil {
+		t.Fatalf("TestCheckManifestsCheckOnlyFinding returned %v", err)
+	}
+	if m.changed() {
+		t.Fatalf("TestCheckManifestsCheckOnlyFinding modified the object")
+	}
+
+	// a threshold never makes the check stricter than no threshold
+	for failOn, expected := range(map[string]int{"": 1, "high": 1, "critical": 0}) {
+		if status := checkManifests(io.Discard, []*manifest{m}, failOn, nil); status != expected {
+			t.Fatalf("TestCheckManifestsCheckOnlyFinding returned %v with -fail-on %q", status, failOn)
+		}
+	}
+	base := baseline{{Kind: "Pod", Name: "web", Rule: "registry", Container: "web"}: false}
+	if status := checkManifests(io.Discard, []*manifest{m}, "", base); status != 0 {
+		t.Fatalf("TestCheckManifestsCheckOnlyFinding failed on a finding of the baseline")
+	}
+}
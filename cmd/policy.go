@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"gopkg.in/yaml.v3"
+)
+
+// runPolicy handles the policy subcommands: `policy show -policy <policy>` prints the effective policy
+func runPolicy(args []string) {
+	if len(args) == 0 || args[0] != "show" {
+		fmt.Println("Usage: manifest-hardening policy show -policy {policyFile, policyName(restricted|baseline)}")
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("policy show", flag.ExitOnError)
+	pol := flags.String("policy", "", "either the path to the policy config file or the name of the policy {restricted, baseline}")
+	flags.Parse(args[1:])
+
+	if *pol == "" {
+		fmt.Println("Error: Missing required flag (policy)")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	pol_cfg, err := policy.Resolve(*pol)
+	if err != nil {
+		fmt.Printf("Error reading config file:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	if err := encodePolicy(os.Stdout, pol_cfg); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func encodePolicy(w io.Writer, pol policy.Policy) (error) {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	return encoder.Encode(pol)
+}
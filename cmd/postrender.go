@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Environment variables read by post-render when the flags are not set
+const (
+	policyEnv = "MANIFEST_HARDENING_POLICY"
+	namespacePoliciesEnv = "MANIFEST_HARDENING_NAMESPACE_POLICIES"
+)
+
+// renderedDocument is a document of the rendered chart and the objects decoded from it
+type renderedDocument struct {
+	raw []byte
+	documents []utils.Document
+}
+
+// runPostRender handles `post-render`, which hardens the manifests rendered by Helm:
+// `helm install --post-renderer manifest-hardening --post-renderer-args post-render`.
+// The manifests are read from stdin and written to stdout, everything else goes to stderr
+func runPostRender(args []string) {
+	flags := flag.NewFlagSet("post-render", flag.ExitOnError)
+	flags.SetOutput(os.Stderr)
+	pol := flags.String("policy", os.Getenv(policyEnv), "either the path to the policy config file or the name of the policy {restricted, baseline}. Defaults to $" + policyEnv)
+	namespacePolicies := flags.String("namespace-policies", os.Getenv(namespacePoliciesEnv), "path to the file mapping namespaces to policies. Defaults to $" + namespacePoliciesEnv)
+	verbose := flags.Bool("verbose", false, "print the changes made to the manifests to stderr")
+	flags.Parse(args)
+
+	if err := postRender(os.Stdin, os.Stdout, os.Stderr, *pol, *namespacePolicies, *verbose); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// postRender hardens the workloads of a multi-document stream. Documents that are not changed by
+// hardening, including the ones that can't be decoded, are written back exactly as they were read
+func postRender(r io.Reader, w io.Writer, log io.Writer, pol string, namespacePolicies string, verbose bool) (error) {
+	var rendered []renderedDocument
+	m := &manifest{}
+
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		data, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if utils.IsEmptyDocument(data) {
+			continue
+		}
+		documents, err := utils.DecodeDocument(data)
+		if err != nil && verbose {
+			fmt.Fprintf(log, "Document kept unchanged, it can't be decoded: %v\n", err)
+		}
+		rendered = append(rendered, renderedDocument{raw: data, documents: documents})
+		m.documents = append(m.documents, documents...)
+	}
+
+	if pol == "" && namespacePolicies == "" && !hasEnforceLabels(m.documents) {
+		return fmt.Errorf("Error: Missing required flag (policy) or %v environment variable", policyEnv)
+	}
+
+	selector, err := policy.NewSelector(pol, namespacePolicies)
+	if err != nil {
+		return fmt.Errorf("Error reading namespace mapping file:\n%s", err)
+	}
+	if err := registerNamespaces(selector, m.documents); err != nil {
+		return err
+	}
+	for _, w := range(selector.Warnings()) {
+		fmt.Fprintln(log, "Warning:", w)
+	}
+	if err := m.harden(selector); err != nil {
+		return err
+	}
+	if verbose {
+		m.printFindings(log, false)
+	}
+
+	// every decoded document has a change, followed by the generated service account, if any
+	changes := m.changes
+	for _, doc := range(rendered) {
+		var objects []change
+		for range(doc.documents) {
+			objects = append(objects, changes[0])
+			changes = changes[1:]
+			for len(changes) > 0 && changes[0].original == nil {
+				objects = append(objects, changes[0])
+				changes = changes[1:]
+			}
+		}
+
+		raw := strings.TrimPrefix(string(doc.raw), "---\n")
+		if !anyChanged(objects) {
+			fmt.Fprintln(w, "---")
+			fmt.Fprint(w, strings.TrimSuffix(raw, "\n") + "\n")
+			continue
+		}
+		for _, c := range(objects) {
+			fmt.Fprintln(w, "---")
+			// keeps the "# Source: <template>" comments added by Helm
+			fmt.Fprint(w, leadingComments(raw))
+			if err := utils.EncodeObjects(w, utils.FormatYAML, c.hardened); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// leadingComments returns the comment lines at the beginning of a document
+func leadingComments(raw string) (string) {
+	var result string
+	for _, line := range(strings.SplitAfter(raw, "\n")) {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			break
+		}
+		result += line
+	}
+	return result
+}
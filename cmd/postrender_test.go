@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// helmRender is a chart rendered by Helm, with an unchanged Service and ConfigMap and a document that is
+// not a Kubernetes object between the workloads
+const helmRender = `# Source: chart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: prod
+spec:
+  selector:
+    matchLabels:
+      app: api
+  template:
+    metadata:
+      labels:
+        app: api
+    spec:
+      containers:
+      - name: api
+        image: nginx
+---
+# Source: chart/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  ports:
+  - port: 80
+---
+# Source: chart/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key:   value   # formatting is kept
+---
+# Source: chart/templates/values.yaml
+replicas: 2
+image: nginx
+---
+# Source: chart/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: web
+    image: nginx
+`
+
+const hardenedRender = `---
+# Source: chart/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  creationTimestamp: null
+  name: api
+  namespace: prod
+spec:
+  selector:
+    matchLabels:
+      app: api
+  strategy: {}
+  template:
+    metadata:
+      creationTimestamp: null
+      labels:
+        app: api
+    spec:
+      containers:
+      - image: nginx
+        name: api
+        resources: {}
+        securityContext:
+          capabilities:
+            drop:
+            - ALL
+      securityContext:
+        runAsNonRoot: true
+        runAsUser: 36636
+        seccompProfile:
+          type: RuntimeDefault
+      serviceAccountName: api
+status: {}
+---
+# Source: chart/templates/deployment.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  creationTimestamp: null
+  name: api
+  namespace: prod
+---
+# Source: chart/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  ports:
+  - port: 80
+---
+# Source: chart/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key:   value   # formatting is kept
+---
+# Source: chart/templates/values.yaml
+replicas: 2
+image: nginx
+---
+# Source: chart/templates/pod.yaml
+apiVersion: v1
+kind: Pod
+metadata:
+  creationTimestamp: null
+  name: web
+spec:
+  containers:
+  - image: nginx
+    name: web
+    resources: {}
+    securityContext:
+      capabilities:
+        drop:
+        - ALL
+  securityContext:
+    runAsNonRoot: true
+    runAsUser: 31193
+    seccompProfile:
+      type: RuntimeDefault
+  serviceAccountName: web
+status: {}
+---
+# Source: chart/templates/pod.yaml
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  creationTimestamp: null
+  name: web
+`
+
+func TestPostRender(t *testing.T) {
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	os.WriteFile(policyPath, []byte("Extends: restricted\nDefaultServiceAccount: false\n"), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if err := postRender(strings.NewReader(helmRender), &stdout, &stderr, policyPath, "", true); err != nil {
+		t.Fatalf("TestPostRender returned %v", err)
+	}
+	if stdout.String() != hardenedRender {
+		t.Fatalf("TestPostRender returned\n%v", stdout.String())
+	}
+	// the findings and the undecodable documents are only reported on stderr
+	for _, expected := range([]string{"it can't be decoded", "Deployment api, policy", "Pod web, policy", "Setting it to api."}) {
+		if !strings.Contains(stderr.String(), expected) {
+			t.Fatalf("TestPostRender did not report %q:\n%v", expected, stderr.String())
+		}
+	}
+}
+
+func TestPostRenderMissingPolicy(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := postRender(strings.NewReader(helmRender), &stdout, &bytes.Buffer{}, "", "", false); err == nil || stdout.Len() != 0 {
+		t.Fatalf("TestPostRenderMissingPolicy returned %v and wrote %q", err, stdout.String())
+	}
+}
@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"github.com/pmezard/go-difflib/difflib"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Files of a test case directory
+const (
+	testInput = "input.yaml" // manifest to harden
+	testPolicy = "policy.yaml" // policy of the case, optional if -policy is set
+	testExpected = "expected.yaml" // expected hardened manifest, including the generated service accounts
+	testReport = "report.yaml" // expected findings, in the format of -report
+)
+
+// testResult is the outcome of a test case
+type testResult struct {
+	name string
+	failures []string // what didn't match the expectations
+	diffs []string
+	err error
+}
+
+func (r testResult) status() (string) {
+	switch {
+		case r.err != nil:
+			return "error"
+		case len(r.failures) > 0:
+			return "fail"
+	}
+	return "pass"
+}
+
+// runTest handles `test`, which hardens the input of every test case directory and compares the result with
+// the expected manifest and findings of the case
+func runTest(args []string) {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	pol := flags.String("policy", "", "policy of the cases without a " + testPolicy + " file, either a path or the name of the policy {restricted, baseline}")
+	color := flags.String("color", "auto", "colorize the diffs {auto, always, never}")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manifest-hardening test [-policy <policy>] <directory>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	dirs, err := testCases(flags.Args())
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(dirs) == 0 {
+		fmt.Printf("Error: no test cases found, a test case is a directory with an %v file\n", testInput)
+		os.Exit(1)
+	}
+
+	var results []testResult
+	for _, dir := range(dirs) {
+		results = append(results, runTestCase(dir, *pol))
+	}
+	os.Exit(printTestResults(os.Stdout, results, useColor(*color, os.Stdout)))
+}
+
+// testCases returns the directories with an input manifest found in the given directories, sorted
+func testCases(roots []string) ([]string, error) {
+	var result []string
+	for _, root := range(roots) {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) (error) {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && d.Name() == testInput {
+				result = append(result, filepath.Dir(p))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func runTestCase(dir string, fallbackPolicy string) (result testResult) {
+	result.name = dir
+
+	pol := fallbackPolicy
+	if _, err := os.Stat(filepath.Join(dir, testPolicy)); err == nil {
+		pol = filepath.Join(dir, testPolicy)
+	}
+	if pol == "" {
+		result.err = fmt.Errorf("no %v and no -policy", testPolicy)
+		return result
+	}
+
+	expectedPath, reportPath := filepath.Join(dir, testExpected), filepath.Join(dir, testReport)
+	hasExpected, hasReport := fileExists(expectedPath), fileExists(reportPath)
+	if !hasExpected && !hasReport {
+		result.err = fmt.Errorf("no %v or %v", testExpected, testReport)
+		return result
+	}
+
+	documents, err := utils.ReadObjects(filepath.Join(dir, testInput))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	selector, err := policy.NewSelector(pol, "")
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if err := registerNamespaces(selector, documents); err != nil {
+		result.err = err
+		return result
+	}
+	m := &manifest{documents: documents}
+	if err := m.harden(selector); err != nil {
+		result.err = err
+		return result
+	}
+
+	if hasExpected {
+		diff, err := compareManifest(expectedPath, m.objects)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		if diff != "" {
+			result.failures = append(result.failures, "hardened manifest differs")
+			result.diffs = append(result.diffs, diff)
+		}
+	}
+
+	if hasReport {
+		diff, err := compareFindings(reportPath, m.reports)
+		if err != nil {
+			result.err = err
+			return result
+		}
+		if diff != "" {
+			result.failures = append(result.failures, "findings differ")
+			result.diffs = append(result.diffs, diff)
+		}
+	}
+	return result
+}
+
+func fileExists(path string) (bool) {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// compareManifest returns the unified diff between the expected manifest and the hardened objects, or ""
+// if they match
+func compareManifest(expectedPath string, objects []runtime.Object) (string, error) {
+	documents, err := utils.ReadObjects(expectedPath)
+	if err != nil {
+		return "", err
+	}
+
+	var expected, actual []interface{}
+	for _, d := range(documents) {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(d.Object.DeepCopyObject())
+		if err != nil {
+			return "", err
+		}
+		expected = append(expected, content)
+	}
+	for _, o := range(objects) {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o.DeepCopyObject())
+		if err != nil {
+			return "", err
+		}
+		actual = append(actual, content)
+	}
+
+	expectedText, err := manifestText(expected)
+	if err != nil {
+		return "", err
+	}
+	actualText, err := manifestText(actual)
+	if err != nil {
+		return "", err
+	}
+	if expectedText == actualText {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A: difflib.SplitLines(expectedText),
+		B: difflib.SplitLines(actualText),
+		FromFile: expectedPath,
+		ToFile: "hardened",
+		Context: 3,
+	})
+}
+
+func manifestText(objects []interface{}) (string, error) {
+	var parts []string
+	for _, o := range(objects) {
+		data, err := sigsyaml.Marshal(o)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, string(data))
+	}
+	return strings.Join(parts, "---\n"), nil
+}
+
+// compareFindings returns the expected findings that were not reported (-) and the unexpected ones (+),
+// or "" if they match. Findings are compared by object, rule, container and exemption, not by message
+func compareFindings(reportPath string, reports []objectReport) (string, error) {
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		return "", err
+	}
+	var expectedReports []objectReport
+	if err := yaml.Unmarshal(data, &expectedReports); err != nil {
+		return "", fmt.Errorf("%v: %w", reportPath, err)
+	}
+
+	expected, actual := findingKeys(expectedReports), findingKeys(reports)
+	var lines []string
+	for key, count := range(expected) {
+		for i := actual[key]; i < count; i++ {
+			lines = append(lines, "-" + key)
+		}
+	}
+	for key, count := range(actual) {
+		for i := expected[key]; i < count; i++ {
+			lines = append(lines, "+" + key)
+		}
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+	sort.Slice(lines, func(i, j int) (bool) {
+		return lines[i][1:] < lines[j][1:]
+	})
+	return fmt.Sprintf("--- %v\n+++ hardened\n%v\n", reportPath, strings.Join(lines, "\n")), nil
+}
+
+// findingKeys counts the findings of the reports by object, rule, container and exemption
+func findingKeys(reports []objectReport) (map[string]int) {
+	result := map[string]int{}
+	for _, r := range(reports) {
+		object := r.Kind + " " + r.Name
+		if r.Namespace != "" {
+			object = r.Kind + " " + r.Namespace + "/" + r.Name
+		}
+		for _, f := range(r.Findings) {
+			key := object + ": " + f.Rule
+			if f.Container != "" {
+				key += " in container " + f.Container
+			}
+			if f.Exempted {
+				key += " (exempted)"
+			}
+			result[key]++
+		}
+	}
+	return result
+}
+
+// printTestResults prints the pass/fail table followed by the diffs of the failed cases, and returns
+// the exit status: 1 if any case failed
+func printTestResults(w io.Writer, results []testResult, color bool) (int) {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "CASE\tRESULT\tDETAILS")
+	failed := 0
+	for _, r := range(results) {
+		details := strings.Join(r.failures, ", ")
+		if r.err != nil {
+			details = r.err.Error()
+		}
+		if r.status() != "pass" {
+			failed++
+		}
+		fmt.Fprintf(table, "%v\t%v\t%v\n", r.name, r.status(), strings.ReplaceAll(details, "\n", " "))
+	}
+	table.Flush()
+
+	for _, r := range(results) {
+		for _, diff := range(r.diffs) {
+			fmt.Fprintf(w, "\n%v:\n", r.name)
+			if color {
+				diff = colorize(diff)
+			}
+			fmt.Fprint(w, diff)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", len(results) - failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtures is the directory of the test cases shipped with the repository
+const fixtures = "../files/tests"
+
+func TestFixtures(t *testing.T) {
+	dirs, err := testCases([]string{fixtures})
+	if err != nil {
+		t.Fatalf("TestFixtures returned %v", err)
+	}
+	if len(dirs) == 0 {
+		t.Fatalf("TestFixtures found no test cases in %v", fixtures)
+	}
+	var results []testResult
+	for _, dir := range(dirs) {
+		results = append(results, runTestCase(dir, ""))
+	}
+	var out bytes.Buffer
+	if status := printTestResults(&out, results, false); status != 0 {
+		t.Fatalf("TestFixtures failed:\n%v", out.String())
+	}
+}
+
+// copyTestCase copies the files of a fixture to a new directory
+func copyTestCase(t *testing.T, name string) (string) {
+	dir := filepath.Join(t.TempDir(), name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Join(fixtures, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range(entries) {
+		data, err := os.ReadFile(filepath.Join(fixtures, name, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, e.Name()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// replaceInFile replaces the first occurrence of old in a file of the test case, which must contain it
+func replaceInFile(t *testing.T, name string, old string, new string) {
+	data, err := os.ReadFile(name)
+	if err != nil || !strings.Contains(string(data), old) {
+		t.Fatalf("%v doesn't contain %q: %v", name, old, err)
+	}
+	if err := os.WriteFile(name, []byte(strings.Replace(string(data), old, new, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunTestCaseFailure(t *testing.T) {
+	dir := copyTestCase(t, "privileged-pod")
+	replaceInFile(t, filepath.Join(dir, testExpected), "privileged: false", "privileged: true")
+	replaceInFile(t, filepath.Join(dir, testReport), "    - rule: host-network\n", "    - rule: host-pid\n")
+
+	passing := copyTestCase(t, "baseline-deployment")
+	missingPolicy := copyTestCase(t, "baseline-deployment")
+	os.Remove(filepath.Join(missingPolicy, testPolicy))
+
+	result := runTestCase(dir, "")
+	if result.status() != "fail" || len(result.failures) != 2 || len(result.diffs) != 2 {
+		t.Fatalf("TestRunTestCaseFailure returned %v %v", result.status(), result.failures)
+	}
+	if !strings.Contains(result.diffs[0], "-      privileged: true\n+      privileged: false\n") {
+		t.Fatalf("TestRunTestCaseFailure returned the diff\n%v", result.diffs[0])
+	}
+	if !strings.Contains(result.diffs[1], "-Pod sample-web-app-pod: host-pid\n") || !strings.Contains(result.diffs[1], "+Pod sample-web-app-pod: host-network\n") {
+		t.Fatalf("TestRunTestCaseFailure returned the findings diff\n%v", result.diffs[1])
+	}
+	if result := runTestCase(missingPolicy, ""); result.status() != "error" {
+		t.Fatalf("TestRunTestCaseFailure returned %v for a case without policy", result.status())
+	}
+
+	var out bytes.Buffer
+	results := []testResult{result, runTestCase(passing, ""), runTestCase(missingPolicy, "")}
+	if status := printTestResults(&out, results, false); status != 1 {
+		t.Fatalf("TestRunTestCaseFailure returned the status %v", status)
+	}
+	lines := strings.Split(out.String(), "\n")
+	for i, expected := range([]string{dir + " fail hardened manifest differs, findings differ", passing + " pass", missingPolicy + " error no policy.yaml and no -policy"}) {
+		if strings.Join(strings.Fields(lines[i+1]), " ") != expected {
+			t.Fatalf("TestRunTestCaseFailure printed %q instead of %q", lines[i+1], expected)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "\n1 passed, 2 failed\n") {
+		t.Fatalf("TestRunTestCaseFailure printed\n%v", out.String())
+	}
+}
@@ -0,0 +1,195 @@
+package export
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+	"reflect"
+	"strings"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Variable is a named CEL expression the checks can reference as variables.<Name>
+type Variable struct {
+	Name string
+	Expression string
+}
+
+// Check is the CEL expression that validates one rule of the policy against an admitted object
+type Check struct {
+	Rule string
+	Expression string
+	Message string
+}
+
+// Variables returns the variables used by the checks, in evaluation order. object is either a Pod or a Deployment
+func Variables() ([]Variable) {
+	annotation := fmt.Sprintf("%q", generator.ExemptAnnotation)
+	return []Variable{
+		{Name: "podSpec", Expression: `object.kind == "Pod" ? object.spec : object.spec.template.spec`},
+		{Name: "containers", Expression: `variables.podSpec.containers + (has(variables.podSpec.initContainers) ? variables.podSpec.initContainers : [])`},
+		{Name: "serviceAccount", Expression: `has(variables.podSpec.serviceAccountName) && variables.podSpec.serviceAccountName != "" ? variables.podSpec.serviceAccountName : (has(variables.podSpec.serviceAccount) && variables.podSpec.serviceAccount != "" ? variables.podSpec.serviceAccount : "default")`},
+		{Name: "workloadExemptions", Expression: fmt.Sprintf(`has(object.metadata.annotations) && %s in object.metadata.annotations ? object.metadata.annotations[%s].split(",") : []`, annotation, annotation)},
+		{Name: "templateExemptions", Expression: fmt.Sprintf(`object.kind != "Pod" && has(object.spec.template.metadata) && has(object.spec.template.metadata.annotations) && %s in object.spec.template.metadata.annotations ? object.spec.template.metadata.annotations[%s].split(",") : []`, annotation, annotation)},
+		{Name: "exemptions", Expression: `(variables.workloadExemptions + variables.templateExemptions).map(r, r.trim())`},
+	}
+}
+
+// Checks returns the CEL checks equivalent to the policy. Rules that are disabled or don't restrict anything are omitted.
+// Every check is skipped when its rule is listed in the exempt annotation of the workload
+func Checks(pol policy.Policy) ([]Check) {
+	var checks []Check
+	podSpec := "variables.podSpec"
+
+	add := func(rule string, message string, expressions ...string) {
+		if !pol.RuleEnabled(rule) {
+			return
+		}
+		checks = append(checks, Check{
+			Rule: rule,
+			Expression: fmt.Sprintf("%q in variables.exemptions || (%s)", rule, strings.Join(expressions, ") && (")),
+			Message: message,
+		})
+	}
+
+	if pol.HostPID == false {
+		add(policy.RuleHostPID, "hostPID is not allowed", absentOr(podSpec, "hostPID", " == false"))
+	}
+	if pol.HostNetwork == false {
+		add(policy.RuleHostNetwork, "hostNetwork is not allowed", absentOr(podSpec, "hostNetwork", " == false"))
+	}
+	if pol.HostIPC == false {
+		add(policy.RuleHostIPC, "hostIPC is not allowed", absentOr(podSpec, "hostIPC", " == false"))
+	}
+
+	var volumeConditions []string
+	for _, v := range(pol.DisallowedVolumes) {
+		volumeConditions = append(volumeConditions, fmt.Sprintf("!has(v.%s)", volumeField(v)))
+	}
+	if !utils.ContainsValue(pol.AllowedVolumes, "*") {
+		var allowed []string
+		for _, v := range(pol.AllowedVolumes) {
+			allowed = append(allowed, fmt.Sprintf("has(v.%s)", volumeField(v)))
+		}
+		if len(allowed) == 0 {
+			allowed = []string{"false"}
+		}
+		volumeConditions = append(volumeConditions, "(" + strings.Join(allowed, " || ") + ")")
+	}
+	if len(volumeConditions) > 0 {
+		add(policy.RuleVolumes, "volume type is not allowed", fmt.Sprintf("!has(%s.volumes) || %s.volumes.all(v, %s)", podSpec, podSpec, strings.Join(volumeConditions, " && ")))
+	}
+
+	if pol.HostProcess == false {
+		add(policy.RuleHostProcess, "hostProcess is not allowed",
+			absentOr(podSpec, "securityContext.windowsOptions.hostProcess", " == false"),
+			allContainers(absentOr("c", "securityContext.windowsOptions.hostProcess", " == false")))
+	}
+	if pol.Privileged == false {
+		add(policy.RulePrivileged, "privileged containers are not allowed", allContainers(absentOr("c", "securityContext.privileged", " == false")))
+	}
+	if !utils.ContainsValue(pol.CapabilitiesAdd, "ALL") {
+		add(policy.RuleCapabilitiesAdd, fmt.Sprintf("only capabilities %v can be added", pol.CapabilitiesAdd),
+			allContainers(absentOr("c", "securityContext.capabilities.add", fmt.Sprintf(".all(cap, cap in %s)", celList(pol.CapabilitiesAdd)))))
+	}
+	if utils.ContainsValue(pol.CapabilitiesDrop, "ALL") {
+		add(policy.RuleCapabilitiesDrop, "containers must drop ALL capabilities",
+			allContainers(present("c", "securityContext.capabilities.drop", `.exists(cap, cap == "ALL")`)))
+	} else if len(pol.CapabilitiesDrop) > 0 {
+		add(policy.RuleCapabilitiesDrop, fmt.Sprintf("containers must drop capabilities %v", pol.CapabilitiesDrop),
+			allContainers(present("c", "securityContext.capabilities.drop", fmt.Sprintf(`.exists(cap, cap == "ALL") || %s.all(d, d in c.securityContext.capabilities.drop)`, celList(pol.CapabilitiesDrop)))))
+	}
+	if pol.ProcMount != "" {
+		add(policy.RuleProcMount, fmt.Sprintf("procMount must be %v", pol.ProcMount), allContainers(absentOr("c", "securityContext.procMount", fmt.Sprintf(" == %q", pol.ProcMount))))
+	}
+
+	seccomp := []string{allContainers(absentOr("c", "securityContext.seccompProfile.type", " in " + celList(pol.Seccomp)))}
+	if !utils.ContainsValue(pol.Seccomp, "Undefined") {
+		seccomp = append(seccomp, present(podSpec, "securityContext.seccompProfile.type", " in " + celList(pol.Seccomp)))
+	}
+	add(policy.RuleSeccomp, fmt.Sprintf("seccomp profile must be one of %v", pol.Seccomp), seccomp...)
+
+	if pol.AllowPrivilegeEscalation == false {
+		add(policy.RuleAllowPrivilegeEscalation, "allowPrivilegeEscalation is not allowed", allContainers(absentOr("c", "securityContext.allowPrivilegeEscalation", " == false")))
+	}
+	if pol.RunAsNonRoot == true {
+		add(policy.RuleRunAsNonRoot, "runAsNonRoot must be true",
+			present(podSpec, "securityContext.runAsNonRoot", " == true"),
+			allContainers(absentOr("c", "securityContext.runAsNonRoot", " == true")))
+	}
+	if pol.RunAsUser == true {
+		add(policy.RuleRunAsUser, "runAsUser must be set to a non-root user",
+			present(podSpec, "securityContext.runAsUser", " != 0"),
+			allContainers(absentOr("c", "securityContext.runAsUser", " != 0")))
+	}
+	if pol.DefaultServiceAccount == false {
+		add(policy.RuleDefaultServiceAccount, "the default service account is not allowed", `variables.serviceAccount != "default"`)
+	}
+	if pol.AutomountServiceAccountToken == false {
+		add(policy.RuleAutomountServiceAccountToken, "automountServiceAccountToken must be false",
+			fmt.Sprintf("variables.serviceAccount in %s || %s", celList(pol.AutomountServiceAccountTokenExemptions), present(podSpec, "automountServiceAccountToken", " == false")))
+	}
+
+	return checks
+}
+
+// Unsupported returns the parts of the policy that can't be enforced by the exported checks
+func Unsupported(pol policy.Policy) ([]string) {
+	result := []string{
+		fmt.Sprintf("per-container %v.<container> annotations are not evaluated", generator.ExemptAnnotation),
+	}
+	for _, ex := range(pol.Exemptions) {
+		result = append(result, fmt.Sprintf("exemption of rules %v for name %q, namespace %q, container %q, image %q is not exported", ex.Rules, ex.Name, ex.Namespace, ex.Container, ex.Image))
+	}
+	for _, r := range(pol.CustomRules) {
+		if pol.RuleEnabled(r.ID) {
+			result = append(result, fmt.Sprintf("custom rule %v is not exported", r.ID))
+		}
+	}
+	for _, w := range(pol.Workloads) {
+		result = append(result, fmt.Sprintf("workload %v %v is not matched by the exported policy", w.APIVersion, w.Kind))
+	}
+	return result
+}
+
+func allContainers(condition string) (string) {
+	return fmt.Sprintf("variables.containers.all(c, %s)", condition)
+}
+
+// absentOr returns an expression that is true if any field of the path is missing or the value meets the condition
+func absentOr(base string, path string, condition string) (string) {
+	var clauses []string
+	parts := strings.Split(path, ".")
+	for i := range(parts) {
+		clauses = append(clauses, fmt.Sprintf("!has(%s.%s)", base, strings.Join(parts[:i+1], ".")))
+	}
+	return "(" + strings.Join(clauses, " || ") + " || " + base + "." + path + condition + ")"
+}
+
+// present returns an expression that is true if every field of the path is set and the value meets the condition
+func present(base string, path string, condition string) (string) {
+	var clauses []string
+	parts := strings.Split(path, ".")
+	for i := range(parts) {
+		clauses = append(clauses, fmt.Sprintf("has(%s.%s)", base, strings.Join(parts[:i+1], ".")))
+	}
+	return "(" + strings.Join(clauses, " && ") + " && (" + base + "." + path + condition + "))"
+}
+
+func celList(values []string) (string) {
+	quoted := []string{}
+	for _, v := range(values) {
+		quoted = append(quoted, fmt.Sprintf("%q", v))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// volumeField returns the JSON name of a volume type, e.g. HostPath -> hostPath
+func volumeField(volumeType string) (string) {
+	field, ok := reflect.TypeOf(corev1.VolumeSource{}).FieldByName(volumeType)
+	if !ok {
+		return volumeType
+	}
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
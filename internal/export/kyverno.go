@@ -0,0 +1,236 @@
+package export
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+	"strings"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// mutation is the remediation of a rule: a patch of the pod spec and/or a patch of each container
+type mutation struct {
+	rule string
+	pod map[string]interface{}
+	container map[string]interface{}
+	serviceAccountExemptions []string
+}
+
+// podSpecPaths are the JMESPath and patch paths of the pod spec of each kind
+var podSpecPaths = []struct {
+	kind string
+	path []string
+}{
+	{kind: "Pod", path: []string{"spec"}},
+	{kind: "Deployment", path: []string{"spec", "template", "spec"}},
+}
+
+// mutations returns the remediation applied by the generator for each rule that can be expressed as a patch
+func mutations(pol policy.Policy) ([]mutation, []string) {
+	var result []mutation
+	notMutated := []string{policy.RuleVolumes, policy.RuleHostProcess, policy.RuleCapabilitiesAdd, policy.RuleDefaultServiceAccount}
+
+	if pol.HostPID == false {
+		result = append(result, mutation{rule: policy.RuleHostPID, pod: map[string]interface{}{"hostPID": false}})
+	}
+	if pol.HostNetwork == false {
+		result = append(result, mutation{rule: policy.RuleHostNetwork, pod: map[string]interface{}{"hostNetwork": false}})
+	}
+	if pol.HostIPC == false {
+		result = append(result, mutation{rule: policy.RuleHostIPC, pod: map[string]interface{}{"hostIPC": false}})
+	}
+	if pol.Privileged == false {
+		result = append(result, mutation{rule: policy.RulePrivileged, container: securityContext("privileged", false)})
+	}
+	if utils.ContainsValue(pol.CapabilitiesDrop, "ALL") {
+		result = append(result, mutation{rule: policy.RuleCapabilitiesDrop, container: securityContext("capabilities", map[string]interface{}{"drop": []interface{}{"ALL"}})})
+	} else if len(pol.CapabilitiesDrop) > 0 {
+		notMutated = append(notMutated, policy.RuleCapabilitiesDrop)
+	}
+	if pol.ProcMount != "" {
+		result = append(result, mutation{rule: policy.RuleProcMount, container: securityContext("procMount", pol.ProcMount)})
+	}
+	if !utils.ContainsValue(pol.Seccomp, "Undefined") {
+		// the generator sets the profile the policy allows, which can only be added here if it is RuntimeDefault
+		if utils.ContainsValue(pol.Seccomp, string(corev1.SeccompProfileTypeRuntimeDefault)) {
+			result = append(result, mutation{rule: policy.RuleSeccomp, pod: securityContext("+(seccompProfile)", map[string]interface{}{"type": string(corev1.SeccompProfileTypeRuntimeDefault)})})
+		} else {
+			notMutated = append(notMutated, policy.RuleSeccomp)
+		}
+	}
+	if pol.AllowPrivilegeEscalation == false {
+		result = append(result, mutation{rule: policy.RuleAllowPrivilegeEscalation, container: securityContext("allowPrivilegeEscalation", false)})
+	}
+	if pol.RunAsNonRoot == true {
+		result = append(result, mutation{rule: policy.RuleRunAsNonRoot, pod: securityContext("runAsNonRoot", true), container: securityContext("runAsNonRoot", true)})
+	}
+	if pol.RunAsUser == true {
+		// the generator derives the uid from the namespace and name of the workload, which a patch can't do
+		notMutated = append(notMutated, policy.RuleRunAsUser)
+	}
+	if pol.AutomountServiceAccountToken == false {
+		result = append(result, mutation{rule: policy.RuleAutomountServiceAccountToken, pod: map[string]interface{}{"automountServiceAccountToken": false}, serviceAccountExemptions: pol.AutomountServiceAccountTokenExemptions})
+	}
+
+	var enabled []mutation
+	for _, m := range(result) {
+		if pol.RuleEnabled(m.rule) {
+			enabled = append(enabled, m)
+		}
+	}
+	return enabled, notMutated
+}
+
+// KyvernoClusterPolicy returns a Kyverno ClusterPolicy with a CEL validate rule for each check and
+// a mutate rule for each remediation of the policy, and the rules that are validated but not mutated
+func KyvernoClusterPolicy(name string, pol policy.Policy) (*unstructured.Unstructured, []string) {
+	var rules []interface{}
+	validated := map[string]bool{}
+
+	for _, c := range(Checks(pol)) {
+		validated[c.Rule] = true
+		rules = append(rules, map[string]interface{}{
+			"name": "validate-" + c.Rule,
+			"match": matchKinds("Pod", "Deployment"),
+			"validate": map[string]interface{}{
+				"cel": map[string]interface{}{
+					"variables": kyvernoVariables(),
+					"expressions": []interface{}{
+						map[string]interface{}{"expression": c.Expression, "message": c.Message},
+					},
+				},
+			},
+		})
+	}
+
+	muts, remediations := mutations(pol)
+	// only the rules with a validate rule are reported, the others are disabled or don't restrict anything
+	notMutated := []string{}
+	for _, rule := range(remediations) {
+		if validated[rule] {
+			notMutated = append(notMutated, rule)
+		}
+	}
+	for _, m := range(muts) {
+		for _, p := range(podSpecPaths) {
+			if m.pod != nil {
+				rules = append(rules, map[string]interface{}{
+					"name": fmt.Sprintf("mutate-%v-%v", m.rule, strings.ToLower(p.kind)),
+					"match": matchKinds(p.kind),
+					"preconditions": preconditions(m, p.path),
+					"mutate": map[string]interface{}{"patchStrategicMerge": nest(p.path, m.pod)},
+				})
+			}
+			if m.container != nil {
+				var foreach []interface{}
+				for _, field := range([]string{"containers", "initContainers"}) {
+					foreach = append(foreach, map[string]interface{}{
+						"list": fmt.Sprintf("request.object.%v.%v || `[]`", strings.Join(p.path, "."), field),
+						"patchStrategicMerge": nest(p.path, map[string]interface{}{
+							field: []interface{}{mergeMaps(map[string]interface{}{"(name)": "{{ element.name }}"}, m.container)},
+						}),
+					})
+				}
+				rules = append(rules, map[string]interface{}{
+					"name": fmt.Sprintf("mutate-%v-containers-%v", m.rule, strings.ToLower(p.kind)),
+					"match": matchKinds(p.kind),
+					"preconditions": preconditions(m, p.path),
+					"mutate": map[string]interface{}{"foreach": foreach},
+				})
+			}
+		}
+	}
+
+	clusterPolicy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "kyverno.io/v1",
+		"kind": "ClusterPolicy",
+		"metadata": map[string]interface{}{
+			"name": name,
+			"annotations": map[string]interface{}{
+				// the rules already cover Pods and Deployments
+				"pod-policies.kyverno.io/autogen-controllers": "none",
+			},
+		},
+		"spec": map[string]interface{}{
+			"validationFailureAction": "Enforce",
+			"background": true,
+			"rules": rules,
+		},
+	}}
+
+	return clusterPolicy, notMutated
+}
+
+func kyvernoVariables() ([]interface{}) {
+	var result []interface{}
+	for _, v := range(Variables()) {
+		result = append(result, map[string]interface{}{"name": v.Name, "expression": v.Expression})
+	}
+	return result
+}
+
+func matchKinds(kinds ...string) (map[string]interface{}) {
+	var list []interface{}
+	for _, k := range(kinds) {
+		list = append(list, k)
+	}
+	return map[string]interface{}{
+		"any": []interface{}{
+			map[string]interface{}{"resources": map[string]interface{}{"kinds": list}},
+		},
+	}
+}
+
+// preconditions skip the mutation when the rule is exempted by annotation or, for the service account
+// token, when the service account is exempted
+func preconditions(m mutation, path []string) (map[string]interface{}) {
+	annotation := fmt.Sprintf(`request.object.metadata.annotations."%v"`, generator.ExemptAnnotation)
+	if len(path) > 1 {
+		annotation += fmt.Sprintf(` || request.object.%v.metadata.annotations."%v"`, strings.Join(path[:len(path)-1], "."), generator.ExemptAnnotation)
+	}
+	conditions := []interface{}{
+		map[string]interface{}{
+			"key": m.rule,
+			"operator": "AnyNotIn",
+			"value": fmt.Sprintf("{{ split(replace_all(%v || '', ' ', ''), ',') }}", annotation),
+		},
+	}
+	if len(m.serviceAccountExemptions) > 0 {
+		var exemptions []interface{}
+		for _, sa := range(m.serviceAccountExemptions) {
+			exemptions = append(exemptions, sa)
+		}
+		conditions = append(conditions, map[string]interface{}{
+			"key": fmt.Sprintf("{{ request.object.%v.serviceAccountName || 'default' }}", strings.Join(path, ".")),
+			"operator": "AnyNotIn",
+			"value": exemptions,
+		})
+	}
+	return map[string]interface{}{"all": conditions}
+}
+
+func securityContext(field string, value interface{}) (map[string]interface{}) {
+	return map[string]interface{}{"securityContext": map[string]interface{}{field: value}}
+}
+
+// nest returns the value nested under the given path
+func nest(path []string, value map[string]interface{}) (map[string]interface{}) {
+	result := value
+	for i := len(path) - 1; i >= 0; i-- {
+		result = map[string]interface{}{path[i]: result}
+	}
+	return result
+}
+
+func mergeMaps(a map[string]interface{}, b map[string]interface{}) (map[string]interface{}) {
+	result := map[string]interface{}{}
+	for k, v := range(a) {
+		result[k] = v
+	}
+	for k, v := range(b) {
+		result[k] = v
+	}
+	return result
+}
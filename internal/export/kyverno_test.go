@@ -0,0 +1,68 @@
+package export
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"reflect"
+	"testing"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestKyvernoClusterPolicy(t *testing.T) {
+	restricted, _ := policy.Builtin("restricted")
+	clusterPolicy, notMutated := KyvernoClusterPolicy("restricted", restricted)
+
+	rules, _, _ := unstructured.NestedSlice(clusterPolicy.Object, "spec", "rules")
+	names := map[string]map[string]interface{}{}
+	for _, r := range(rules) {
+		rule := r.(map[string]interface{})
+		names[rule["name"].(string)] = rule
+	}
+
+	for _, c := range(Checks(restricted)) {
+		rule, ok := names["validate-" + c.Rule]
+		if !ok {
+			t.Fatalf("TestKyvernoClusterPolicy did not generate a validate rule for %v", c.Rule)
+		}
+		expression, _, _ := unstructured.NestedSlice(rule, "validate", "cel", "expressions")
+		if expression[0].(map[string]interface{})["expression"] != c.Expression {
+			t.Fatalf("TestKyvernoClusterPolicy returned a different expression for %v", c.Rule)
+		}
+	}
+
+	privileged, ok := names["mutate-privileged-containers-deployment"]
+	if !ok {
+		t.Fatalf("TestKyvernoClusterPolicy did not generate the privileged mutation for deployments")
+	}
+	foreach, _, _ := unstructured.NestedSlice(privileged, "mutate", "foreach")
+	patch, _, _ := unstructured.NestedSlice(foreach[0].(map[string]interface{}), "patchStrategicMerge", "spec", "template", "spec", "containers")
+	value, _, _ := unstructured.NestedBool(patch[0].(map[string]interface{}), "securityContext", "privileged")
+	if len(foreach) != 2 || value != false || patch[0].(map[string]interface{})["(name)"] != "{{ element.name }}" {
+		t.Fatalf("TestKyvernoClusterPolicy returned %v", privileged)
+	}
+
+	if _, ok := names["mutate-host-network-pod"]; !ok {
+		t.Fatalf("TestKyvernoClusterPolicy did not generate the hostNetwork mutation for pods")
+	}
+	if _, ok := names["mutate-volumes-pod"]; ok || len(notMutated) == 0 {
+		t.Fatalf("TestKyvernoClusterPolicy mutated volumes")
+	}
+
+	// the uid assigned by the generator depends on the workload, so it is only validated
+	if _, ok := names["mutate-run-as-user-pod"]; ok || !utils.ContainsValue(notMutated, policy.RuleRunAsUser) {
+		t.Fatalf("TestKyvernoClusterPolicy mutated runAsUser: %v", notMutated)
+	}
+	if again, _ := KyvernoClusterPolicy("restricted", restricted); !reflect.DeepEqual(again, clusterPolicy) {
+		t.Fatalf("TestKyvernoClusterPolicy returned a different policy for the same input")
+	}
+
+	restricted.EnabledRules = []string{policy.RulePrivileged}
+	if _, notMutated := KyvernoClusterPolicy("restricted", restricted); len(notMutated) != 0 {
+		t.Fatalf("TestKyvernoClusterPolicy reported disabled rules as not mutated: %v", notMutated)
+	}
+
+	// the generated policy must be serializable
+	if _, err := clusterPolicy.MarshalJSON(); err != nil {
+		t.Fatalf("TestKyvernoClusterPolicy returned %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package export
+
+import (
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	admissionv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidatingAdmissionPolicy returns the policy that enforces the checks on Pods and Deployments,
+// and the binding that denies the objects failing them in every namespace
+func ValidatingAdmissionPolicy(name string, checks []Check) (*admissionv1beta1.ValidatingAdmissionPolicy, *admissionv1beta1.ValidatingAdmissionPolicyBinding) {
+	failurePolicy := admissionv1beta1.Fail
+	operations := []admissionv1.OperationType{admissionv1.Create, admissionv1.Update}
+
+	vap := &admissionv1beta1.ValidatingAdmissionPolicy{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1beta1", Kind: "ValidatingAdmissionPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: admissionv1beta1.ValidatingAdmissionPolicySpec{
+			FailurePolicy: &failurePolicy,
+			MatchConstraints: &admissionv1beta1.MatchResources{
+				ResourceRules: []admissionv1beta1.NamedRuleWithOperations{
+					{RuleWithOperations: admissionv1.RuleWithOperations{
+						Operations: operations,
+						Rule: admissionv1.Rule{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods"}},
+					}},
+					{RuleWithOperations: admissionv1.RuleWithOperations{
+						Operations: operations,
+						Rule: admissionv1.Rule{APIGroups: []string{"apps"}, APIVersions: []string{"v1"}, Resources: []string{"deployments"}},
+					}},
+				},
+			},
+		},
+	}
+
+	for _, v := range(Variables()) {
+		vap.Spec.Variables = append(vap.Spec.Variables, admissionv1beta1.Variable{Name: v.Name, Expression: v.Expression})
+	}
+
+	for _, c := range(checks) {
+		vap.Spec.Validations = append(vap.Spec.Validations, admissionv1beta1.Validation{
+			Expression: c.Expression,
+			Message: c.Message + " (" + c.Rule + ")",
+		})
+	}
+
+	binding := &admissionv1beta1.ValidatingAdmissionPolicyBinding{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1beta1", Kind: "ValidatingAdmissionPolicyBinding"},
+		ObjectMeta: metav1.ObjectMeta{Name: name + "-binding"},
+		Spec: admissionv1beta1.ValidatingAdmissionPolicyBindingSpec{
+			PolicyName: name,
+			ValidationActions: []admissionv1beta1.ValidationAction{admissionv1beta1.Deny},
+		},
+	}
+
+	return vap, binding
+}
@@ -0,0 +1,166 @@
+package export
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"testing"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/ext"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// evaluateChecks runs the checks the way the API server does, returning the result of each rule
+func evaluateChecks(t *testing.T, obj runtime.Object, checks []Check) (map[string]bool) {
+	env, err := cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("variables", cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+	)
+	if err != nil {
+		t.Fatalf("evaluateChecks returned %v", err)
+	}
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatalf("evaluateChecks returned %v", err)
+	}
+
+	variables := map[string]interface{}{}
+	eval := func(expression string) (ref.Val) {
+		ast, issues := env.Compile(expression)
+		if issues != nil && issues.Err() != nil {
+			t.Fatalf("evaluateChecks could not compile %v: %v", expression, issues.Err())
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			t.Fatalf("evaluateChecks returned %v", err)
+		}
+		val, _, err := program.Eval(map[string]interface{}{"object": object, "variables": variables})
+		if err != nil {
+			t.Fatalf("evaluateChecks could not evaluate %v: %v", expression, err)
+		}
+		return val
+	}
+
+	for _, v := range(Variables()) {
+		variables[v.Name] = eval(v.Expression)
+	}
+
+	results := map[string]bool{}
+	for _, c := range(checks) {
+		results[c.Rule] = eval(c.Expression).Value().(bool)
+	}
+	return results
+}
+
+func readManifest(t *testing.T, filepath string) (runtime.Object) {
+	documents, err := utils.ReadObjects(filepath)
+	if err != nil || len(documents) != 1 {
+		t.Fatalf("readManifest returned %v for %v", err, filepath)
+	}
+	return documents[0].Object
+}
+
+func TestChecksSampleManifests(t *testing.T) {
+	restricted, _ := policy.Builtin("restricted")
+	checks := Checks(restricted)
+
+	cases := map[string]map[string]bool{
+		"../../files/manifests/pod.yaml": {
+			policy.RuleHostPID: true,
+			policy.RuleHostNetwork: false,
+			policy.RuleHostIPC: true,
+			policy.RuleVolumes: false,
+			policy.RuleHostProcess: false,
+			policy.RulePrivileged: false,
+			policy.RuleCapabilitiesAdd: false,
+			policy.RuleCapabilitiesDrop: false,
+			policy.RuleProcMount: true,
+			policy.RuleSeccomp: false,
+			policy.RuleAllowPrivilegeEscalation: false,
+			policy.RuleRunAsNonRoot: false,
+			policy.RuleRunAsUser: false,
+		},
+		"../../files/manifests/deployment.yaml": {
+			policy.RuleHostPID: true,
+			policy.RuleHostNetwork: false,
+			policy.RuleHostIPC: true,
+			policy.RuleVolumes: false,
+			policy.RuleHostProcess: false,
+			policy.RulePrivileged: false,
+			policy.RuleCapabilitiesAdd: false,
+			policy.RuleCapabilitiesDrop: false,
+			policy.RuleProcMount: true,
+			policy.RuleSeccomp: false,
+			policy.RuleAllowPrivilegeEscalation: true,
+			policy.RuleRunAsNonRoot: false,
+			policy.RuleRunAsUser: true,
+		},
+	}
+
+	for manifest, expected := range(cases) {
+		results := evaluateChecks(t, readManifest(t, manifest), checks)
+		if len(results) != len(expected) {
+			t.Fatalf("TestChecksSampleManifests returned %v checks for %v", len(results), manifest)
+		}
+		for rule, allowed := range(expected) {
+			if results[rule] != allowed {
+				t.Fatalf("TestChecksSampleManifests returned %v for rule %v in %v", results[rule], rule, manifest)
+			}
+		}
+	}
+}
+
+func TestChecksExemptAnnotation(t *testing.T) {
+	restricted, _ := policy.Builtin("restricted")
+	pod := readManifest(t, "../../files/manifests/pod.yaml").(*corev1.Pod)
+	pod.Annotations = map[string]string{"manifest-hardening/exempt": "privileged, host-network"}
+
+	results := evaluateChecks(t, pod, Checks(restricted))
+
+	if !results[policy.RulePrivileged] || !results[policy.RuleHostNetwork] {
+		t.Fatalf("TestChecksExemptAnnotation did not skip the exempted rules")
+	}
+	if results[policy.RuleVolumes] {
+		t.Fatalf("TestChecksExemptAnnotation skipped a rule that is not exempted")
+	}
+}
+
+func TestChecksCompliantPod(t *testing.T) {
+	restricted, _ := policy.Builtin("restricted")
+	restricted.DefaultServiceAccount = false
+	restricted.AutomountServiceAccountToken = false
+	nonRoot, user, automount, escalation := true, int64(1000), false, false
+
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web"},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: "web",
+			AutomountServiceAccountToken: &automount,
+			SecurityContext: &corev1.PodSecurityContext{
+				RunAsNonRoot: &nonRoot,
+				RunAsUser: &user,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			},
+			Containers: []corev1.Container{{
+				Name: "web",
+				Image: "nginx",
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: &escalation,
+					Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}, Add: []corev1.Capability{"NET_BIND_SERVICE"}},
+				},
+			}},
+			Volumes: []corev1.Volume{{Name: "cache", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+		},
+	}
+
+	for rule, allowed := range(evaluateChecks(t, pod, Checks(restricted))) {
+		if !allowed {
+			t.Fatalf("TestChecksCompliantPod returned false for rule %v", rule)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package generator
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/google/cel-go/cel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// programs caches the compiled expressions of the custom rules, since they are evaluated for every object
+var programs sync.Map
+
+// celRule is a custom rule of the policy file
+type celRule struct {
+	rule policy.CustomRule
+	program cel.Program
+}
+
+func newCELRule(rule policy.CustomRule) (celRule, error) {
+	if program, ok := programs.Load(rule.Expression); ok {
+		return celRule{rule: rule, program: program.(cel.Program)}, nil
+	}
+	program, err := rule.Program()
+	if err != nil {
+		return celRule{}, fmt.Errorf("custom rule %v: %w", rule.ID, err)
+	}
+	programs.Store(rule.Expression, program)
+	return celRule{rule: rule, program: program}, nil
+}
+
+func (r celRule) ID() (string) {
+	return r.rule.ID
+}
+
+func (r celRule) Description() (string) {
+	return r.rule.Description
+}
+
+func (r celRule) Dependencies() ([]string) {
+	return nil
+}
+
+func (r celRule) Check(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+	return r.Fix(ps.DeepCopy(), ctx)
+}
+
+func (r celRule) Fix(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+	var output []Finding
+
+	podSpec, err := runtime.DefaultUnstructuredConverter.ToUnstructured(ps)
+	if err != nil {
+		return []Finding{r.finding("", fmt.Sprintf("Could not evaluate rule %v: %v", r.rule.ID, err))}
+	}
+	metadata, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&ctx.Meta)
+	if err != nil {
+		return []Finding{r.finding("", fmt.Sprintf("Could not evaluate rule %v: %v", r.rule.ID, err))}
+	}
+	vars := map[string]interface{}{"podSpec": podSpec, "metadata": metadata, "container": nil}
+
+	if r.rule.Scope != policy.ScopeContainer {
+		if source := ctx.PodExempt(r.rule.ID); source != "" {
+			return append(output, podExemption(r.rule.ID, source))
+		}
+		message, failed, err := r.evaluate(vars, ps, "")
+		if err != nil {
+			ctx.fail(err)
+		} else if failed {
+			output = append(output, r.finding("", message))
+		}
+		return output
+	}
+
+	for _, containers := range([]*[]corev1.Container{&ps.Containers, &ps.InitContainers}) {
+		for i := range(*containers) {
+			container := &(*containers)[i]
+			if source := ctx.ContainerExempt(r.rule.ID, *container); source != "" {
+				output = append(output, containerExemption(r.rule.ID, container.Name, source))
+				continue
+			}
+			vars["container"], err = runtime.DefaultUnstructuredConverter.ToUnstructured(container)
+			if err != nil {
+				output = append(output, r.finding(container.Name, fmt.Sprintf("Could not evaluate rule %v in container %v: %v", r.rule.ID, container.Name, err)))
+				continue
+			}
+			message, failed, err := r.evaluate(vars, container, container.Name)
+			if err != nil {
+				ctx.fail(err)
+			} else if failed {
+				output = append(output, r.finding(container.Name, message))
+			}
+		}
+	}
+	return output
+}
+
+// evaluate runs the expression and, if it is false, applies the patch to the target (the pod spec or a container).
+// It returns the message of the finding and whether the rule failed, or an error if the patch can't be applied
+func (r celRule) evaluate(vars map[string]interface{}, target interface{}, container string) (string, bool, error) {
+	where := ""
+	if container != "" {
+		where = " in container " + container
+	}
+
+	val, _, err := r.program.Eval(vars)
+	if err != nil {
+		return fmt.Sprintf("Could not evaluate rule %v%v: %v", r.rule.ID, where, err), true, nil
+	}
+	if compliant, ok := val.Value().(bool); !ok {
+		return fmt.Sprintf("Rule %v%v returned %v instead of a bool", r.rule.ID, where, val.Value()), true, nil
+	} else if compliant {
+		return "", false, nil
+	}
+
+	message := r.rule.Message
+	if message == "" {
+		message = fmt.Sprintf("Rule %v failed", r.rule.ID)
+	}
+	message = strings.TrimSuffix(message, ".") + where + "."
+
+	if len(r.rule.Patch) > 0 {
+		if err := applyPatch(target, r.rule.Patch); err != nil {
+			return message, true, fmt.Errorf("Error applying the patch of rule %v%v:\n%s", r.rule.ID, where, err)
+		}
+		message += " Patch applied."
+	}
+	return message, true, nil
+}
+
+func (r celRule) finding(container string, message string) (Finding) {
+	return Finding{Rule: r.rule.ID, Container: container, Message: message, Severity: r.rule.Severity}
+}
+
+// applyPatch applies a JSON patch to the pod spec or container the target points to
+func applyPatch(target interface{}, operations []policy.PatchOperation) (error) {
+	patchJSON, err := json.Marshal(operations)
+	if err != nil {
+		return err
+	}
+	patch, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		return err
+	}
+	original, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	patched, err := patch.Apply(original)
+	if err != nil {
+		return err
+	}
+	// the patched value is decoded into a new value, so that fields removed by the patch don't keep their
+	// previous value, and the target is only replaced if it decodes
+	switch t := target.(type) {
+		case *corev1.PodSpec:
+			var result corev1.PodSpec
+			if err := json.Unmarshal(patched, &result); err != nil {
+				return err
+			}
+			*t = result
+		case *corev1.Container:
+			var result corev1.Container
+			if err := json.Unmarshal(patched, &result); err != nil {
+				return err
+			}
+			*t = result
+		default:
+			return fmt.Errorf("cannot patch %T", target)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ExemptAnnotation lists the rules skipped for the whole workload, e.g. "privileged,capabilities-add".
+// Rules can be skipped for a single container with ExemptAnnotation + "." + containerName
+const ExemptAnnotation = "manifest-hardening/exempt"
+
+type exemptions struct {
+	meta metav1.ObjectMeta
+	pol policy.Policy
+}
+
+func newExemptions(meta metav1.ObjectMeta, pol policy.Policy) (exemptions) {
+	return exemptions{meta: meta, pol: pol}
+}
+
+// podExempt returns the source of the exemption of a pod level rule, or "" if the rule applies
+func (e exemptions) podExempt(rule string) (string) {
+	if annotationContainsRule(e.meta.Annotations[ExemptAnnotation], rule) {
+		return "annotation"
+	}
+	for _, ex := range(e.pol.Exemptions) {
+		if ex.Container != "" || ex.Image != "" {
+			continue
+		}
+		if e.matchesWorkload(ex) && ruleInList(ex.Rules, rule) {
+			return "policy"
+		}
+	}
+	return ""
+}
+
+// containerExempt returns the source of the exemption of a container level rule, or "" if the rule applies
+func (e exemptions) containerExempt(rule string, container corev1.Container) (string) {
+	if annotationContainsRule(e.meta.Annotations[ExemptAnnotation], rule) || annotationContainsRule(e.meta.Annotations[ExemptAnnotation + "." + container.Name], rule) {
+		return "annotation"
+	}
+	for _, ex := range(e.pol.Exemptions) {
+		if e.matchesWorkload(ex) && globMatches(ex.Container, container.Name) && globMatches(ex.Image, container.Image) && ruleInList(ex.Rules, rule) {
+			return "policy"
+		}
+	}
+	return ""
+}
+
+func (e exemptions) matchesWorkload(ex policy.Exemption) (bool) {
+	return globMatches(ex.Name, e.meta.Name) && globMatches(ex.Namespace, e.meta.Namespace)
+}
+
+func podExemption(rule string, source string) (Finding) {
+	return Finding{Rule: rule, Exempted: true, Message: fmt.Sprintf("Exception: rule %v exempted for pod by %v. Skipping.", rule, source)}
+}
+
+func containerExemption(rule string, container string, source string) (Finding) {
+	return Finding{Rule: rule, Container: container, Exempted: true, Message: fmt.Sprintf("Exception: rule %v exempted for container %v by %v. Skipping.", rule, container, source)}
+}
+
+func annotationContainsRule(annotation string, rule string) (bool) {
+	if annotation == "" {
+		return false
+	}
+	return ruleInList(strings.Split(annotation, ","), rule)
+}
+
+func ruleInList(rules []string, rule string) (bool) {
+	for _, r := range(rules) {
+		if strings.TrimSpace(r) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatches(pattern string, value string) (bool) {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
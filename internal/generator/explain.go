@@ -0,0 +1,202 @@
+package generator
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+)
+
+// Explanation is the rationale of a rule, shown by `explain` and -explain
+type Explanation struct {
+	Rule string
+	Description string
+	Control string // Pod Security Standards control the rule comes from, empty for rules outside of the standards
+	Risk string // what an attacker gains when the rule is not enforced
+	Remediation string // what hardening changes
+	Containers bool // the rule applies to containers, which can be exempted one by one
+	Severity string // default severity of the findings of the rule
+}
+
+// Explainer is implemented by rules that describe their rationale. Rules that don't are explained
+// by their description only
+type Explainer interface {
+	Explain() Explanation
+}
+
+// explanations of the builtin rules, see https://kubernetes.io/docs/concepts/security/pod-security-standards/
+var explanations = map[string]Explanation{
+	policy.RuleHostPID: {
+		Severity: "high",
+		Control: "Host Namespaces (baseline)",
+		Risk: "A pod in the host PID namespace sees every process of the node, can read their environment and can signal or trace them.",
+		Remediation: "Sets hostPID to false.",
+	},
+	policy.RuleHostNetwork: {
+		Severity: "high",
+		Control: "Host Namespaces (baseline)",
+		Risk: "A pod in the host network namespace can bind to the ports of the node, sniff its traffic and reach services listening on localhost, bypassing network policies.",
+		Remediation: "Sets hostNetwork to false.",
+	},
+	policy.RuleHostIPC: {
+		Severity: "high",
+		Control: "Host Namespaces (baseline)",
+		Risk: "A pod in the host IPC namespace can read and write the shared memory of every process of the node.",
+		Remediation: "Sets hostIPC to false.",
+	},
+	policy.RuleVolumes: {
+		Severity: "high",
+		Control: "HostPath Volumes (baseline), Volume Types (restricted)",
+		Risk: "hostPath volumes expose the filesystem of the node, e.g. the container runtime socket or the kubelet credentials, which is a common way out of a container.",
+		Remediation: "Deletes the volumes whose type is not in AllowedVolumes or is in DisallowedVolumes.",
+	},
+	policy.RuleHostProcess: {
+		Severity: "critical",
+		Control: "HostProcess (baseline)",
+		Risk: "Windows HostProcess containers run directly on the node, with the privileges of an administrator of the host.",
+		Remediation: "Sets windowsOptions.hostProcess to false in the pod and container security contexts.",
+		Containers: true,
+	},
+	policy.RulePrivileged: {
+		Severity: "critical",
+		Control: "Privileged Containers (baseline)",
+		Risk: "A privileged container has every capability and access to the devices of the node, so it is effectively root on the host.",
+		Remediation: "Sets privileged to false.",
+		Containers: true,
+	},
+	policy.RuleCapabilitiesAdd: {
+		Severity: "high",
+		Control: "Capabilities (baseline, restricted)",
+		Risk: "Capabilities such as NET_ADMIN or SYS_ADMIN grant parts of the root privileges of the kernel, which widen the attack surface of a compromised container.",
+		Remediation: "Removes the added capabilities that are not in CapabilitiesAdd.",
+		Containers: true,
+	},
+	policy.RuleCapabilitiesDrop: {
+		Severity: "medium",
+		Control: "Capabilities (restricted)",
+		Risk: "The default capabilities of the container runtime, e.g. NET_RAW, let a compromised container spoof network traffic or abuse kernel features it doesn't need.",
+		Remediation: "Sets capabilities.drop to [ALL], or adds the missing capabilities listed in CapabilitiesDrop.",
+		Containers: true,
+	},
+	policy.RuleProcMount: {
+		Severity: "high",
+		Control: "/proc Mount Type (baseline)",
+		Risk: "An Unmasked /proc mount exposes the kernel interfaces that the container runtime hides, which can leak information about the node or be used to escape.",
+		Remediation: "Sets procMount to the ProcMount of the policy.",
+		Containers: true,
+	},
+	policy.RuleSeccomp: {
+		Severity: "medium",
+		Control: "Seccomp (baseline, restricted)",
+		Risk: "Without a seccomp profile every system call is allowed, including the rarely used ones behind most kernel exploits.",
+		Remediation: "Sets seccompProfile.type to RuntimeDefault in the pod security context, and in the containers whose profile is not allowed. If the policy doesn't allow RuntimeDefault, the profiles of the containers are removed when undefined profiles are allowed, and Localhost-only policies are not remediated.",
+		Containers: true,
+	},
+	policy.RuleAllowPrivilegeEscalation: {
+		Severity: "medium",
+		Control: "Privilege Escalation (restricted)",
+		Risk: "A process can gain more privileges than its parent, e.g. through setuid binaries, and become root inside the container.",
+		Remediation: "Sets allowPrivilegeEscalation to false.",
+		Containers: true,
+	},
+	policy.RuleRunAsNonRoot: {
+		Severity: "medium",
+		Control: "Running as Non-root (restricted)",
+		Risk: "Root in a container is root on the node as soon as the container is escaped, e.g. through a kernel or runtime vulnerability.",
+		Remediation: "Sets runAsNonRoot to true in the pod security context and in the containers that set it to false.",
+		Containers: true,
+	},
+	policy.RuleRunAsUser: {
+		Severity: "medium",
+		Control: "Running as Non-root user (restricted)",
+		Risk: "Running with uid 0 gives the process root privileges inside the container, and on the node if it escapes.",
+		Remediation: "Sets runAsUser to a non-root uid derived from the namespace and name of the workload.",
+		Containers: true,
+	},
+	policy.RuleDefaultServiceAccount: {
+		Severity: "low",
+		Risk: "Every pod of the namespace shares the default service account, so the permissions granted to one workload are granted to all of them.",
+		Remediation: "Sets serviceAccountName to the name of the workload and generates the service account.",
+	},
+	policy.RuleAutomountServiceAccountToken: {
+		Severity: "low",
+		Risk: "A mounted token lets anyone who compromises the pod call the Kubernetes API with the permissions of its service account.",
+		Remediation: "Sets automountServiceAccountToken to false.",
+	},
+}
+
+func (r builtinRule) Explain() (Explanation) {
+	e := explanations[r.id]
+	e.Rule, e.Description = r.id, r.description
+	return e
+}
+
+func (r celRule) Explain() (Explanation) {
+	e := Explanation{Rule: r.rule.ID, Description: r.rule.Description, Containers: r.rule.Scope == policy.ScopeContainer, Severity: r.rule.Severity}
+	if len(r.rule.Patch) > 0 {
+		e.Remediation = "Applies the patch of the rule."
+	}
+	return e
+}
+
+// Explain returns the explanation of a registered rule or of a custom rule of the policy, with the severity
+// set in the policy
+func Explain(id string, pol policy.Policy) (Explanation, error) {
+	rules, err := EnabledRules(policy.Policy{CustomRules: pol.CustomRules})
+	if err != nil {
+		return Explanation{}, err
+	}
+	for _, r := range(rules) {
+		if r.ID() != id {
+			continue
+		}
+		e := Explanation{Rule: r.ID(), Description: r.Description()}
+		if explainer, ok := r.(Explainer); ok {
+			e = explainer.Explain()
+		}
+		e.Severity = severity(r, pol)
+		return e, nil
+	}
+	return Explanation{}, fmt.Errorf("unknown rule %q", id)
+}
+
+// severity returns the severity of the findings of a rule: the one set in the policy, or the default
+// of the rule, or medium
+func severity(r Rule, pol policy.Policy) (string) {
+	if s, ok := pol.Severities[r.ID()]; ok {
+		return s
+	}
+	if explainer, ok := r.(Explainer); ok && explainer.Explain().Severity != "" {
+		return explainer.Explain().Severity
+	}
+	return "medium"
+}
+
+// findingSeverity returns the severity of a finding: the one set in the policy for its rule, or the one
+// set by the rule in the finding, or the severity of the rule
+func findingSeverity(r Rule, f Finding, pol policy.Policy) (string) {
+	if _, ok := pol.Severities[r.ID()]; !ok && utils.ContainsValue(policy.SeverityLevels, f.Severity) {
+		return f.Severity
+	}
+	return severity(r, pol)
+}
+
+// Annotation returns the annotation that exempts the workload from the rule, or only the container if it
+// is set and the rule applies to containers
+func (e Explanation) Annotation(container string) (string) {
+	if e.Containers && container != "" {
+		return fmt.Sprintf("%v.%v: %v", ExemptAnnotation, container, e.Rule)
+	}
+	return fmt.Sprintf("%v: %v", ExemptAnnotation, e.Rule)
+}
+
+// Exemptions returns the ways to exempt a workload from the rule
+func (e Explanation) Exemptions() ([]string) {
+	result := []string{fmt.Sprintf("Annotate the workload or its pod template with %v", e.Annotation(""))}
+	match := "Name and Namespace"
+	if e.Containers {
+		result = append(result, fmt.Sprintf("Annotate it with %v to only exempt one container", e.Annotation("<container>")))
+		match = "Name, Namespace, Container and Image"
+	}
+	result = append(result, fmt.Sprintf("Ask the owners of the policy to add %v to the Rules of an entry of its Exemptions, matching the workload by %v", e.Rule, match))
+	return result
+}
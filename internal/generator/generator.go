@@ -6,17 +6,36 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"fmt"
 	"edurra/manifest-hardening/internal/utils"
 	"errors"
+	"reflect"
+	"strings"
+	"k8s.io/apimachinery/pkg/api/equality"
 )
 
-func GenerateHardenedObject(obj runtime.Object, gVK *schema.GroupVersionKind, pol policy.Policy) (runtime.Object, []string, error) {
+// SupportedKind returns true if objects of the given kind are hardened as built-in kinds: apps/v1
+// Deployments and v1 Pods. Kinds with the same name in other groups or versions are not
+func SupportedKind(gVK *schema.GroupVersionKind) (bool) {
+	return *gVK == appsv1.SchemeGroupVersion.WithKind("Deployment") || *gVK == corev1.SchemeGroupVersion.WithKind("Pod")
+}
+
+// builtinKind returns the kind of the object if it is a built-in kind, or "" otherwise
+func builtinKind(gVK *schema.GroupVersionKind) (string) {
+	if SupportedKind(gVK) {
+		return gVK.Kind
+	}
+	return ""
+}
+
+func GenerateHardenedObject(obj runtime.Object, gVK *schema.GroupVersionKind, pol policy.Policy) (runtime.Object, []Finding, error) {
 
 	var newObject runtime.Object
-	var output []string
+	var output []Finding
+	var err error
 
-	switch gVK.Kind {
+	switch builtinKind(gVK) {
 		case "Deployment":
 			deployment, ok := obj.(*appsv1.Deployment)
 			if !ok {
@@ -24,7 +43,7 @@ func GenerateHardenedObject(obj runtime.Object, gVK *schema.GroupVersionKind, po
 			}
 			newObject = deployment.DeepCopy()
 			podSpec := &newObject.(*appsv1.Deployment).Spec.Template.Spec
-			*podSpec, output = evaluatePodSpec(*podSpec, pol)
+			*podSpec, output, err = evaluatePodSpec(*podSpec, workloadMeta(deployment.ObjectMeta, deployment.Spec.Template.ObjectMeta), pol)
 
 		case "Pod":
 			pod, ok := obj.(*corev1.Pod)
@@ -33,167 +52,365 @@ func GenerateHardenedObject(obj runtime.Object, gVK *schema.GroupVersionKind, po
 			}
 			newObject = pod.DeepCopy() 
 			podSpec := &newObject.(*corev1.Pod).Spec
-			*podSpec, output = evaluatePodSpec(*podSpec, pol)
+			*podSpec, output, err = evaluatePodSpec(*podSpec, pod.ObjectMeta, pol)
 
 		default:
-			return obj, output, errors.New("Error, unkown resource kind")
+			w, configured := pol.Workload(gVK.GroupVersion().String(), gVK.Kind)
+			if !configured {
+				return obj, output, errors.New("Error, unkown resource kind")
+			}
+			return hardenWorkload(obj, w, pol)
 	}
 
-	return newObject, output, nil
+	return newObject, output, err
 }
 
-func evaluatePodSpec(ps corev1.PodSpec, pol policy.Policy) (corev1.PodSpec, []string){
-	var output []string
+// GenerateServiceAccount returns the dedicated ServiceAccount that replaces the default one
+// in the hardened object, or nil if the policy allows the default service account
+func GenerateServiceAccount(obj runtime.Object, gVK *schema.GroupVersionKind, pol policy.Policy) (runtime.Object) {
+	var ps corev1.PodSpec
+	var meta metav1.ObjectMeta
 
-	if ps.SecurityContext == nil {
+	switch builtinKind(gVK) {
+		case "Deployment":
+			deployment, ok := obj.(*appsv1.Deployment)
+			if !ok {
+				return nil
+			}
+			ps, meta = deployment.Spec.Template.Spec, workloadMeta(deployment.ObjectMeta, deployment.Spec.Template.ObjectMeta)
+		case "Pod":
+			pod, ok := obj.(*corev1.Pod)
+			if !ok {
+				return nil
+			}
+			ps, meta = pod.Spec, pod.ObjectMeta
+		default:
+			w, configured := pol.Workload(gVK.GroupVersion().String(), gVK.Kind)
+			if !configured {
+				return nil
+			}
+			// the pod specs of a workload share the service account named after it
+			podSpecs, err := embeddedPodSpecs(obj, w)
+			if err != nil || len(podSpecs) == 0 {
+				return nil
+			}
+			ps, meta = podSpecs[0].spec, podSpecs[0].meta
+			for _, embedded := range(podSpecs) {
+				if usesDefaultServiceAccount(embedded.spec) {
+					ps, meta = embedded.spec, embedded.meta
+					break
+				}
+			}
+	}
+
+	if pol.DefaultServiceAccount == true || meta.Name == "" || !usesDefaultServiceAccount(ps) || newExemptions(meta, pol).podExempt(policy.RuleDefaultServiceAccount) != "" {
+		return nil
+	}
+
+	serviceAccount := &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{Name: meta.Name, Namespace: meta.Namespace},
+	}
+	if pol.AutomountServiceAccountToken == false {
+		serviceAccount.AutomountServiceAccountToken = new(bool)
+	}
+	return serviceAccount
+}
+
+// VerifyIdempotent hardens an already hardened object again and returns an error if the second pass
+// changes it, generates a service account or reports findings. Exemptions and the findings of custom
+// rules without a patch, which can't remediate the object, are reported on every pass and are ignored
+func VerifyIdempotent(hardened runtime.Object, gVK *schema.GroupVersionKind, pol policy.Policy) (error) {
+	again, findings, err := GenerateHardenedObject(hardened, gVK, pol)
+	if err != nil {
+		return err
+	}
+
+	checkOnly := map[string]bool{}
+	for _, r := range(pol.CustomRules) {
+		if len(r.Patch) == 0 {
+			checkOnly[r.ID] = true
+		}
+	}
+	var problems []string
+	for _, f := range(findings) {
+		if !f.Exempted && !checkOnly[f.Rule] {
+			problems = append(problems, f.Message)
+		}
+	}
+	if !equality.Semantic.DeepEqual(hardened, again) {
+		problems = append(problems, "The hardened object is modified again.")
+	}
+	if GenerateServiceAccount(hardened, gVK, pol) != nil {
+		problems = append(problems, "A service account is generated again.")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Error, hardening is not idempotent:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// workloadMeta returns the metadata of the workload, including the annotations of its pod template
+func workloadMeta(meta metav1.ObjectMeta, template metav1.ObjectMeta) (metav1.ObjectMeta) {
+	result := *meta.DeepCopy()
+	if result.Annotations == nil {
+		result.Annotations = map[string]string{}
+	}
+	for k, v := range(template.Annotations) {
+		if _, ok := result.Annotations[k]; !ok {
+			result.Annotations[k] = v
+		}
+	}
+	return result
+}
+
+func evaluatePodSpec(ps corev1.PodSpec, meta metav1.ObjectMeta, pol policy.Policy) (corev1.PodSpec, []Finding, error){
+	var output []Finding
+	ctx := newContext(meta, pol)
+
+	rules, err := EnabledRules(pol)
+	if err != nil {
+		return ps, output, err
+	}
+
+	addedSecurityContext := ps.SecurityContext == nil
+	if addedSecurityContext {
 		ps.SecurityContext = &corev1.PodSecurityContext{}
 	}
 
+	for _, rule := range(rules) {
+		findings := rule.Fix(&ps, ctx)
+		for i := range(findings) {
+			if !findings[i].Exempted {
+				findings[i].Severity = findingSeverity(rule, findings[i], pol)
+			}
+		}
+		output = append(output, findings...)
+	}
+
+	if ctx.err != nil {
+		return ps, output, ctx.err
+	}
+
+	// the security context is only added if a rule sets one of its fields
+	if addedSecurityContext && reflect.DeepEqual(*ps.SecurityContext, corev1.PodSecurityContext{}) {
+		ps.SecurityContext = nil
+	}
+
+	return ps, output, nil
+}
+
+func assessHostPID(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleHostPID); source != "" {
+		return append(output, podExemption(policy.RuleHostPID, source))
+	}
 	if pol.HostPID == false && ps.HostPID != pol.HostPID {
-		output = append(output, fmt.Sprintf("hostPID does not match. Setting it to %v. ", pol.HostPID))
+		output = append(output, Finding{Rule: policy.RuleHostPID, Message: fmt.Sprintf("hostPID does not match. Setting it to %v. ", pol.HostPID)})
 		ps.HostPID = pol.HostPID
 	}
+	return output
+}
 
+func assessHostNetwork(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleHostNetwork); source != "" {
+		return append(output, podExemption(policy.RuleHostNetwork, source))
+	}
 	if pol.HostNetwork == false && ps.HostNetwork != pol.HostNetwork {
-		output = append(output, fmt.Sprintf("hostNetwork does not match. Setting it to %v. ", pol.HostNetwork))
+		output = append(output, Finding{Rule: policy.RuleHostNetwork, Message: fmt.Sprintf("hostNetwork does not match. Setting it to %v. ", pol.HostNetwork)})
 		ps.HostNetwork = pol.HostNetwork
 	}
+	return output
+}
 
+func assessHostIPC(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleHostIPC); source != "" {
+		return append(output, podExemption(policy.RuleHostIPC, source))
+	}
 	if pol.HostIPC == false && ps.HostIPC != pol.HostIPC {
-		output = append(output, fmt.Sprintf("hostIPC does not match. Setting it to %v. ", pol.HostIPC))
+		output = append(output, Finding{Rule: policy.RuleHostIPC, Message: fmt.Sprintf("hostIPC does not match. Setting it to %v. ", pol.HostIPC)})
 		ps.HostIPC = pol.HostIPC
 	}
+	return output
+}
 
+func assessVolumes(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleVolumes); source != "" {
+		return append(output, podExemption(policy.RuleVolumes, source))
+	}
 	if ps.Volumes != nil {
 		newVolumes := []corev1.Volume{}
 		for _, volume := range(ps.Volumes) {
 			if utils.VolumeIsDisallowed(volume, pol.DisallowedVolumes) || (!utils.VolumeIsAllowed(volume, pol.AllowedVolumes)) {
-				output = append(output, fmt.Sprintf("%s Volume not allowed. It has been deleted.", volume.Name))
+				output = append(output, Finding{Rule: policy.RuleVolumes, Message: fmt.Sprintf("%s Volume not allowed. It has been deleted.", volume.Name)})
 			} else {
 				newVolumes = append(newVolumes, volume)
 			}
 		}
 		ps.Volumes = newVolumes
 	}
+	return output
+}
 
-	// Assess hostProcess for PodSecurityContext
+func assessPodHostProcess(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleHostProcess); source != "" {
+		return append(output, podExemption(policy.RuleHostProcess, source))
+	}
 	if ps.SecurityContext.WindowsOptions != nil {
 		if ps.SecurityContext.WindowsOptions.HostProcess != nil {
 			if pol.HostProcess == false && *ps.SecurityContext.WindowsOptions.HostProcess != pol.HostProcess {
-				output = append(output, fmt.Sprintf("Host process does not match in pod security context. Setting it to %v.", pol.HostProcess))
+				output = append(output, Finding{Rule: policy.RuleHostProcess, Message: fmt.Sprintf("Host process does not match in pod security context. Setting it to %v.", pol.HostProcess)})
 				*ps.SecurityContext.WindowsOptions.HostProcess = pol.HostProcess
 			}
 		}
 	}
+	return output
+}
 
-	// hostProcess can be overwritten at container level
-	ps.Containers, output = assessHostProcess(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessHostProcess(ps.InitContainers, pol, output)
-	}
-
-	ps.Containers, output = assessPrivileged(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessPrivileged(ps.InitContainers, pol, output)
-	}
-
-	ps.Containers, output = assessCapabilitiesAdd(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessCapabilitiesAdd(ps.InitContainers, pol, output)
-	}
-
-	ps.Containers, output = assessCapabilitiesDrop(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessCapabilitiesDrop(ps.InitContainers, pol, output)
-	}
-
-	ps.Containers, output = assessProcMount(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessProcMount(ps.InitContainers, pol, output)
+func assessPodSeccomp(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleSeccomp); source != "" {
+		return append(output, podExemption(policy.RuleSeccomp, source))
 	}
-
 	if !utils.ContainsValue(pol.Seccomp, "Undefined") {
+		// the policy requires a profile, so the remediation is never to remove it
+		profile, ok := seccompRemediation(pol)
 		if ps.SecurityContext.SeccompProfile != nil {
 			if !utils.ContainsValue(pol.Seccomp, string(ps.SecurityContext.SeccompProfile.Type)) {
-				output = append(output, fmt.Sprintf("Seccomp in pod security context not included in allowed values. Setting it to %v. ", "Default"))
-				ps.SecurityContext.SeccompProfile.Type = corev1.SeccompProfileType("Default")
+				if !ok {
+					return append(output, Finding{Rule: policy.RuleSeccomp, Message: "Seccomp in pod security context not included in allowed values. It can't be fixed, since the policy allows no profile that can be set."})
+				}
+				output = append(output, Finding{Rule: policy.RuleSeccomp, Message: fmt.Sprintf("Seccomp in pod security context not included in allowed values. Setting it to %v. ", profile.Type)})
+				ps.SecurityContext.SeccompProfile = profile
 			}
 		} else {
-			output = append(output, fmt.Sprintf("Seccomp in pod security context is undefined. Setting it to %v. ", "Default"))
-			ps.SecurityContext.SeccompProfile = &corev1.SeccompProfile{
-				Type: corev1.SeccompProfileType("Default"),
+			if !ok {
+				return append(output, Finding{Rule: policy.RuleSeccomp, Message: "Seccomp in pod security context is undefined. It can't be fixed, since the policy allows no profile that can be set."})
 			}
-			
+			output = append(output, Finding{Rule: policy.RuleSeccomp, Message: fmt.Sprintf("Seccomp in pod security context is undefined. Setting it to %v. ", profile.Type)})
+			ps.SecurityContext.SeccompProfile = profile
 		}
 	}
+	return output
+}
 
-	ps.Containers, output = assessSeccomp(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessSeccomp(ps.InitContainers, pol, output)
+// seccompRemediation returns the profile that replaces a seccomp profile the policy doesn't allow: RuntimeDefault,
+// no profile (nil) if the policy allows undefined profiles, or Unconfined. ok is false if the policy allows none
+// of them, e.g. only Localhost profiles, whose file can't be guessed
+func seccompRemediation(pol policy.Policy) (profile *corev1.SeccompProfile, ok bool) {
+	switch {
+		case utils.ContainsValue(pol.Seccomp, string(corev1.SeccompProfileTypeRuntimeDefault)):
+			return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}, true
+		case utils.ContainsValue(pol.Seccomp, "Undefined"):
+			return nil, true
+		case utils.ContainsValue(pol.Seccomp, string(corev1.SeccompProfileTypeUnconfined)):
+			return &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}, true
 	}
+	return nil, false
+}
 
-	ps.Containers, output = assessAllowPrivilegeEscalation(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessAllowPrivilegeEscalation(ps.InitContainers, pol, output)
+func assessPodRunAsNonRoot(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleRunAsNonRoot); source != "" {
+		return append(output, podExemption(policy.RuleRunAsNonRoot, source))
 	}
-
 	if pol.RunAsNonRoot == true {
 		if ps.SecurityContext.RunAsNonRoot == nil {
 			ps.SecurityContext.RunAsNonRoot = new(bool)
 			*ps.SecurityContext.RunAsNonRoot = true
-			output = append(output, fmt.Sprintf("Pod RunAsNonRoot does not match. It was modified."))
+			output = append(output, Finding{Rule: policy.RuleRunAsNonRoot, Message: fmt.Sprintf("Pod RunAsNonRoot does not match. It was modified.")})
 		}
 		if *ps.SecurityContext.RunAsNonRoot == false {
 			*ps.SecurityContext.RunAsNonRoot = true
-			output = append(output, fmt.Sprintf("Pod RunAsNonRoot does not match. It was modified."))
+			output = append(output, Finding{Rule: policy.RuleRunAsNonRoot, Message: fmt.Sprintf("Pod RunAsNonRoot does not match. It was modified.")})
 		}
 	}
+	return output
+}
 
-	ps.Containers, output = assessRunAsNonRoot(ps.Containers, pol, output)
-
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessRunAsNonRoot(ps.InitContainers, pol, output)
+func assessPodRunAsUser(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleRunAsUser); source != "" {
+		return append(output, podExemption(policy.RuleRunAsUser, source))
 	}
-
-	user := utils.RandomUser()
 	if pol.RunAsUser == true {
 		if ps.SecurityContext.RunAsUser == nil {
 			ps.SecurityContext.RunAsUser = new(int64)
-			*ps.SecurityContext.RunAsUser = user
-			output = append(output, fmt.Sprintf("RunAsUser does not match for pod. Assigning random user value."))
+			*ps.SecurityContext.RunAsUser = ctx.User
+			output = append(output, Finding{Rule: policy.RuleRunAsUser, Message: fmt.Sprintf("RunAsUser does not match for pod. Setting it to %v.", ctx.User)})
 		} else {
 			if *ps.SecurityContext.RunAsUser == 0 {
-				*ps.SecurityContext.RunAsUser = user
-				output = append(output, fmt.Sprintf("RunAsUser does not match for pod. Assigning random user value."))
+				*ps.SecurityContext.RunAsUser = ctx.User
+				output = append(output, Finding{Rule: policy.RuleRunAsUser, Message: fmt.Sprintf("RunAsUser does not match for pod. Setting it to %v.", ctx.User)})
 			}
 		}
 	}
+	return output
+}
+
+func assessDefaultServiceAccount(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleDefaultServiceAccount); source != "" {
+		return append(output, podExemption(policy.RuleDefaultServiceAccount, source))
+	}
+	// the service account is named after the object, so objects without a name (e.g. with a generateName) are skipped
+	if ctx.Meta.Name == "" {
+		return output
+	}
+	if pol.DefaultServiceAccount == false && usesDefaultServiceAccount(*ps) {
+		output = append(output, Finding{Rule: policy.RuleDefaultServiceAccount, Message: fmt.Sprintf("Default service account not allowed. Setting it to %v.", ctx.Meta.Name)})
+		ps.ServiceAccountName = ctx.Meta.Name
+		ps.DeprecatedServiceAccount = ""
+	}
+	return output
+}
 
-	ps.Containers, output = assessRunAsUser(ps.Containers, pol, user, output)
+func assessAutomountServiceAccountToken(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	pol := ctx.Policy
+	if source := ctx.PodExempt(policy.RuleAutomountServiceAccountToken); source != "" {
+		return append(output, podExemption(policy.RuleAutomountServiceAccountToken, source))
+	}
+	if pol.AutomountServiceAccountToken == false && !utils.ContainsValue(pol.AutomountServiceAccountTokenExemptions, serviceAccountName(*ps)) {
+		if ps.AutomountServiceAccountToken == nil || *ps.AutomountServiceAccountToken == true {
+			output = append(output, Finding{Rule: policy.RuleAutomountServiceAccountToken, Message: fmt.Sprintf("automountServiceAccountToken does not match. Setting it to %v.", pol.AutomountServiceAccountToken)})
+			ps.AutomountServiceAccountToken = new(bool)
+		}
+	}
+	return output
+}
 
-	if ps.InitContainers != nil {
-		ps.InitContainers, output = assessRunAsUser(ps.InitContainers, pol, user, output)
+// serviceAccountName returns the service account the pod will run as
+func serviceAccountName(ps corev1.PodSpec) (string) {
+	if ps.ServiceAccountName != "" {
+		return ps.ServiceAccountName
+	}
+	if ps.DeprecatedServiceAccount != "" {
+		return ps.DeprecatedServiceAccount
 	}
+	return "default"
+}
 
-	return ps, output
+func usesDefaultServiceAccount(ps corev1.PodSpec) (bool) {
+	return serviceAccountName(ps) == "default"
 }
 
 
-func assessPrivileged(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
+func assessPrivileged(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
+		if source := ex.containerExempt(policy.RulePrivileged, container); source != "" {
+			output = append(output, containerExemption(policy.RulePrivileged, container.Name, source))
+			continue
+		}
 		if container.SecurityContext == nil {
 			container.SecurityContext = &corev1.SecurityContext{}
 		}
 		if container.SecurityContext.Privileged != nil {
 			if pol.Privileged == false && *container.SecurityContext.Privileged != pol.Privileged {
-				output = append(output, fmt.Sprintf("Privileged does not match in container %v. Setting it to %v.", container.Name, pol.Privileged))
+				output = append(output, Finding{Rule: policy.RulePrivileged, Container: container.Name, Message: fmt.Sprintf("Privileged does not match in container %v. Setting it to %v.", container.Name, pol.Privileged)})
 				*container.SecurityContext.Privileged = pol.Privileged
 			}
 		}	
@@ -201,15 +418,19 @@ func assessPrivileged(containers []corev1.Container, pol policy.Policy, output [
 	return containers, output
 }
 
-func assessHostProcess(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
+func assessHostProcess(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
+		if source := ex.containerExempt(policy.RuleHostProcess, container); source != "" {
+			output = append(output, containerExemption(policy.RuleHostProcess, container.Name, source))
+			continue
+		}
 		if container.SecurityContext == nil {
 			container.SecurityContext = &corev1.SecurityContext{}
 		}
 		if container.SecurityContext.WindowsOptions != nil {
 			if container.SecurityContext.WindowsOptions.HostProcess != nil {
 				if pol.HostProcess == false && *container.SecurityContext.WindowsOptions.HostProcess != pol.HostProcess {
-					output = append(output, fmt.Sprintf("HostProcess does not match in container %v. Setting it to %v.", container.Name, pol.HostProcess))
+					output = append(output, Finding{Rule: policy.RuleHostProcess, Container: container.Name, Message: fmt.Sprintf("HostProcess does not match in container %v. Setting it to %v.", container.Name, pol.HostProcess)})
 					*container.SecurityContext.WindowsOptions.HostProcess = pol.HostProcess
 				}
 			}	
@@ -219,18 +440,15 @@ func assessHostProcess(containers []corev1.Container, pol policy.Policy, output
 }
 
 
-func assessCapabilitiesAdd(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
+func assessCapabilitiesAdd(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
-		if container.SecurityContext == nil {
-			container.SecurityContext = &corev1.SecurityContext{}
+		if source := ex.containerExempt(policy.RuleCapabilitiesAdd, container); source != "" {
+			output = append(output, containerExemption(policy.RuleCapabilitiesAdd, container.Name, source))
+			continue
 		}
-		if container.SecurityContext.Capabilities == nil {
-			container.SecurityContext.Capabilities = &corev1.Capabilities{
-				Add:  []corev1.Capability{}, 
-				Drop: []corev1.Capability{}, 
-			}
-		} else if container.SecurityContext.Capabilities.Add == nil {
-			container.SecurityContext.Capabilities.Add = []corev1.Capability{}
+		// containers without added capabilities are left as they are, instead of getting an empty list
+		if container.SecurityContext == nil || container.SecurityContext.Capabilities == nil || len(container.SecurityContext.Capabilities.Add) == 0 {
+			continue
 		}
 		
 		newCapabilities := []corev1.Capability{}
@@ -238,39 +456,41 @@ func assessCapabilitiesAdd(containers []corev1.Container, pol policy.Policy, out
 			if (utils.ContainsValue(pol.CapabilitiesAdd, "ALL") || utils.ContainsValue(pol.CapabilitiesAdd, string(capability))) {
 				newCapabilities = append(newCapabilities, capability)
 			} else {
-				output = append(output, fmt.Sprintf("Capability: %v not allowed in container %v.", string(capability), container.Name))
+				output = append(output, Finding{Rule: policy.RuleCapabilitiesAdd, Container: container.Name, Message: fmt.Sprintf("Capability: %v not allowed in container %v.", string(capability), container.Name)})
 			}
 		}
-		container.SecurityContext.Capabilities.Add = newCapabilities
-		
-		
+		if len(newCapabilities) < len(container.SecurityContext.Capabilities.Add) {
+			container.SecurityContext.Capabilities.Add = newCapabilities
+		}
 	}
 	return containers, output
 }
 
-func assessCapabilitiesDrop(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
-	for _, container := range(containers) {
-		if container.SecurityContext == nil {
-			container.SecurityContext = &corev1.SecurityContext{}
+func assessCapabilitiesDrop(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
+	for i := range(containers) {
+		// the security context is modified in place, since it may be added to a container without one
+		container := &containers[i]
+		if source := ex.containerExempt(policy.RuleCapabilitiesDrop, *container); source != "" {
+			output = append(output, containerExemption(policy.RuleCapabilitiesDrop, container.Name, source))
+			continue
 		}
 
-		if container.SecurityContext.Capabilities == nil {
-			container.SecurityContext.Capabilities = &corev1.Capabilities{
-				Add:  []corev1.Capability{}, 
-				Drop: []corev1.Capability{}, 
-			}
-		} else if container.SecurityContext.Capabilities.Drop == nil {
-			container.SecurityContext.Capabilities.Drop = []corev1.Capability{}
+		var drop []corev1.Capability
+		if container.SecurityContext != nil && container.SecurityContext.Capabilities != nil {
+			drop = container.SecurityContext.Capabilities.Drop
 		}
 		
 		if utils.ContainsValue(pol.CapabilitiesDrop, "ALL") {
-			container.SecurityContext.Capabilities.Drop = []corev1.Capability{"ALL"}
-			output = append(output, fmt.Sprintf("Dropped all capabilities in container %v.", container.Name))
+			if !utils.CapabilityInList(drop, "ALL") {
+				capabilities(container).Drop = []corev1.Capability{"ALL"}
+				output = append(output, Finding{Rule: policy.RuleCapabilitiesDrop, Container: container.Name, Message: fmt.Sprintf("Dropped all capabilities in container %v.", container.Name)})
+			}
 		} else {
 			for _, capability := range(pol.CapabilitiesDrop) {
-				if !utils.CapabilityInList(container.SecurityContext.Capabilities.Drop, capability) {
-					container.SecurityContext.Capabilities.Drop = append(container.SecurityContext.Capabilities.Drop, corev1.Capability(capability))
-					output = append(output, fmt.Sprintf("Dropped capability: %v in container %v.", string(capability), container.Name))
+				if !utils.CapabilityInList(drop, capability) && !utils.CapabilityInList(drop, "ALL") {
+					drop = append(drop, corev1.Capability(capability))
+					capabilities(container).Drop = drop
+					output = append(output, Finding{Rule: policy.RuleCapabilitiesDrop, Container: container.Name, Message: fmt.Sprintf("Dropped capability: %v in container %v.", string(capability), container.Name)})
 				}
 			}
 		}
@@ -280,14 +500,29 @@ func assessCapabilitiesDrop(containers []corev1.Container, pol policy.Policy, ou
 	return containers, output
 }
 
-func assessProcMount(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
+// capabilities returns the capabilities of the container, adding its security context if needed
+func capabilities(container *corev1.Container) (*corev1.Capabilities) {
+	if container.SecurityContext == nil {
+		container.SecurityContext = &corev1.SecurityContext{}
+	}
+	if container.SecurityContext.Capabilities == nil {
+		container.SecurityContext.Capabilities = &corev1.Capabilities{}
+	}
+	return container.SecurityContext.Capabilities
+}
+
+func assessProcMount(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
+		if source := ex.containerExempt(policy.RuleProcMount, container); source != "" {
+			output = append(output, containerExemption(policy.RuleProcMount, container.Name, source))
+			continue
+		}
 		if container.SecurityContext == nil {
 			container.SecurityContext = &corev1.SecurityContext{}
 		}
 		if container.SecurityContext.ProcMount != nil {
 			if  *container.SecurityContext.ProcMount != corev1.ProcMountType(pol.ProcMount) && corev1.ProcMountType(pol.ProcMount) != "" {
-				output = append(output, fmt.Sprintf("ProcMount does not match in container %v. Setting it to %v.", container.Name, pol.ProcMount))
+				output = append(output, Finding{Rule: policy.RuleProcMount, Container: container.Name, Message: fmt.Sprintf("ProcMount does not match in container %v. Setting it to %v.", container.Name, pol.ProcMount)})
 				*container.SecurityContext.ProcMount  = corev1.ProcMountType(pol.ProcMount) 
 			}
 		}
@@ -295,29 +530,47 @@ func assessProcMount(containers []corev1.Container, pol policy.Policy, output []
 	return containers, output
 }
 
-func assessSeccomp(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
-	for _, container := range(containers) {
-		if container.SecurityContext == nil {
-			container.SecurityContext = &corev1.SecurityContext{}
+func assessSeccomp(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
+	profile, ok := seccompRemediation(pol)
+	for i := range(containers) {
+		// the profile is replaced in place, since it may be removed from the container
+		container := &containers[i]
+		if source := ex.containerExempt(policy.RuleSeccomp, *container); source != "" {
+			output = append(output, containerExemption(policy.RuleSeccomp, container.Name, source))
+			continue
 		}
-		if container.SecurityContext.SeccompProfile != nil {
-			if !utils.ContainsValue(pol.Seccomp, string(container.SecurityContext.SeccompProfile.Type)) {
-				output = append(output, fmt.Sprintf("Seccomp profile not allowed in container %v. Setting it to %v.", container.Name, "Default"))
-				container.SecurityContext.SeccompProfile.Type = corev1.SeccompProfileType("Default")
-			}
+		if container.SecurityContext == nil || container.SecurityContext.SeccompProfile == nil {
+			continue
+		}
+		if utils.ContainsValue(pol.Seccomp, string(container.SecurityContext.SeccompProfile.Type)) {
+			continue
+		}
+		switch {
+			case !ok:
+				output = append(output, Finding{Rule: policy.RuleSeccomp, Container: container.Name, Message: fmt.Sprintf("Seccomp profile not allowed in container %v. It can't be fixed, since the policy allows no profile that can be set.", container.Name)})
+			case profile == nil:
+				output = append(output, Finding{Rule: policy.RuleSeccomp, Container: container.Name, Message: fmt.Sprintf("Seccomp profile not allowed in container %v. It has been removed.", container.Name)})
+				container.SecurityContext.SeccompProfile = nil
+			default:
+				output = append(output, Finding{Rule: policy.RuleSeccomp, Container: container.Name, Message: fmt.Sprintf("Seccomp profile not allowed in container %v. Setting it to %v.", container.Name, profile.Type)})
+				container.SecurityContext.SeccompProfile = profile.DeepCopy()
 		}
 	}
 	return containers, output
 }
 
-func assessAllowPrivilegeEscalation(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
+func assessAllowPrivilegeEscalation(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
+		if source := ex.containerExempt(policy.RuleAllowPrivilegeEscalation, container); source != "" {
+			output = append(output, containerExemption(policy.RuleAllowPrivilegeEscalation, container.Name, source))
+			continue
+		}
 		if container.SecurityContext == nil {
 			container.SecurityContext = &corev1.SecurityContext{}
 		}
 		if container.SecurityContext.AllowPrivilegeEscalation != nil {
 			if pol.AllowPrivilegeEscalation == false && *container.SecurityContext.AllowPrivilegeEscalation != pol.Privileged {
-				output = append(output, fmt.Sprintf("AllowPrivilegeEscalation does not match in container %v. Setting it to %v.", container.Name, pol.AllowPrivilegeEscalation))
+				output = append(output, Finding{Rule: policy.RuleAllowPrivilegeEscalation, Container: container.Name, Message: fmt.Sprintf("AllowPrivilegeEscalation does not match in container %v. Setting it to %v.", container.Name, pol.AllowPrivilegeEscalation)})
 				*container.SecurityContext.AllowPrivilegeEscalation = pol.AllowPrivilegeEscalation
 			}
 		}	
@@ -325,15 +578,19 @@ func assessAllowPrivilegeEscalation(containers []corev1.Container, pol policy.Po
 	return containers, output
 }
 
-func assessRunAsNonRoot(containers []corev1.Container, pol policy.Policy, output []string) ([]corev1.Container, []string) {
+func assessRunAsNonRoot(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
+		if source := ex.containerExempt(policy.RuleRunAsNonRoot, container); source != "" {
+			output = append(output, containerExemption(policy.RuleRunAsNonRoot, container.Name, source))
+			continue
+		}
 		if container.SecurityContext == nil {
 			container.SecurityContext = &corev1.SecurityContext{}
 		} 
 
 		if pol.RunAsNonRoot == true  && container.SecurityContext.RunAsNonRoot != nil {
 			if *container.SecurityContext.RunAsNonRoot == false {
-				output = append(output, fmt.Sprintf("RunAsNonRoot does not match in container %v. Setting it to %v.", container.Name, pol.RunAsNonRoot))
+				output = append(output, Finding{Rule: policy.RuleRunAsNonRoot, Container: container.Name, Message: fmt.Sprintf("RunAsNonRoot does not match in container %v. Setting it to %v.", container.Name, pol.RunAsNonRoot)})
 				*container.SecurityContext.RunAsNonRoot = pol.RunAsNonRoot
 			}
 		}
@@ -341,14 +598,18 @@ func assessRunAsNonRoot(containers []corev1.Container, pol policy.Policy, output
 	return containers, output
 }
 
-func assessRunAsUser(containers []corev1.Container, pol policy.Policy, user int64, output []string) ([]corev1.Container, []string) {
+func assessRunAsUser(containers []corev1.Container, pol policy.Policy, ex exemptions, user int64, output []Finding) ([]corev1.Container, []Finding) {
 	for _, container := range(containers) {
+		if source := ex.containerExempt(policy.RuleRunAsUser, container); source != "" {
+			output = append(output, containerExemption(policy.RuleRunAsUser, container.Name, source))
+			continue
+		}
 		if container.SecurityContext == nil {
 			container.SecurityContext = &corev1.SecurityContext{}
 		} 
 		if pol.RunAsUser == true  && container.SecurityContext.RunAsUser != nil {
 			if *container.SecurityContext.RunAsUser == 0 {
-				output = append(output, fmt.Sprintf("RunAsUser does not match in container %v. Setting it to %v.", container.Name, user))
+				output = append(output, Finding{Rule: policy.RuleRunAsUser, Container: container.Name, Message: fmt.Sprintf("RunAsUser does not match in container %v. Setting it to %v.", container.Name, user)})
 				*container.SecurityContext.RunAsUser = user
 			}
 		}
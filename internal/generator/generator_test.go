@@ -2,9 +2,13 @@ package generator
 
 import (
 	"testing"
+	"reflect"
 	"edurra/manifest-hardening/internal/policy"
 	"edurra/manifest-hardening/internal/utils"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 
@@ -20,7 +24,7 @@ func TestAssessPrivileged(t *testing.T) {
 	
 	containers := []corev1.Container{container1, container2}
 
-	result1 := assessPrivileged(containers, policy1)
+	result1, _ := assessPrivileged(containers, policy1, exemptions{}, nil)
 
 
 	for _, c := range(result1) {
@@ -29,7 +33,7 @@ func TestAssessPrivileged(t *testing.T) {
 		} 
 	}
 
-	result2 := assessPrivileged(containers, policy2)
+	result2, _ := assessPrivileged(containers, policy2, exemptions{}, nil)
 
 	for i, c := range(result2) {
 		if result2[i].SecurityContext.Privileged != c.SecurityContext.Privileged {
@@ -54,7 +58,7 @@ func TestAssessCapabilitiesAdd(t *testing.T) {
 
 	containers := []corev1.Container{container1, container2, container3}
 
-	result1 := assessCapabilitiesAdd(containers, policy1)
+	result1, _ := assessCapabilitiesAdd(containers, policy1, exemptions{}, nil)
 
 	for i, c := range(result1) {
 
@@ -68,7 +72,7 @@ func TestAssessCapabilitiesAdd(t *testing.T) {
 
 	}
 
-	result2 := assessCapabilitiesAdd(containers, policy2)
+	result2, _ := assessCapabilitiesAdd(containers, policy2, exemptions{}, nil)
 
 	for i, c := range(result2) {
 
@@ -85,7 +89,7 @@ func TestAssessCapabilitiesAdd(t *testing.T) {
 		}
 	}
 
-	result3 := assessCapabilitiesAdd(containers, policy3)
+	result3, _ := assessCapabilitiesAdd(containers, policy3, exemptions{}, nil)
 
 	for i, c := range(result3) {
 
@@ -100,4 +104,507 @@ func TestAssessCapabilitiesAdd(t *testing.T) {
 		}
 	}
 	
-}
\ No newline at end of file
+}
+
+func TestEvaluatePodSpecServiceAccount(t *testing.T) {
+	policy1 := policy.Policy{AutomountServiceAccountToken: false, DefaultServiceAccount: false}
+	policy2 := policy.Policy{AutomountServiceAccountToken: false, AutomountServiceAccountTokenExemptions: []string{"monitoring"}, DefaultServiceAccount: true}
+
+	podSpec1 := corev1.PodSpec{}
+	result1, _, _ := evaluatePodSpec(podSpec1, metav1.ObjectMeta{Name: "web"}, policy1)
+
+	if result1.ServiceAccountName != "web" {
+		t.Fatalf("TestEvaluatePodSpecServiceAccount returned service account %v", result1.ServiceAccountName)
+	}
+	if result1.AutomountServiceAccountToken == nil || *result1.AutomountServiceAccountToken != false {
+		t.Fatalf("TestEvaluatePodSpecServiceAccount did not disable automountServiceAccountToken")
+	}
+
+	automountTrue := true
+	podSpec2 := corev1.PodSpec{ServiceAccountName: "monitoring", AutomountServiceAccountToken: &automountTrue}
+	result2, _, _ := evaluatePodSpec(podSpec2, metav1.ObjectMeta{Name: "web"}, policy2)
+
+	if result2.ServiceAccountName != "monitoring" {
+		t.Fatalf("TestEvaluatePodSpecServiceAccount returned service account %v", result2.ServiceAccountName)
+	}
+	if *result2.AutomountServiceAccountToken != true {
+		t.Fatalf("TestEvaluatePodSpecServiceAccount modified automountServiceAccountToken for an exempted service account")
+	}
+
+	// objects without a name have no name for their service account
+	result3, output3, _ := evaluatePodSpec(corev1.PodSpec{}, metav1.ObjectMeta{GenerateName: "web-"}, policy1)
+	for _, f := range(output3) {
+		if f.Rule == policy.RuleDefaultServiceAccount {
+			t.Fatalf("TestEvaluatePodSpecServiceAccount returned %v for an object without name", f)
+		}
+	}
+	if result3.ServiceAccountName != "" {
+		t.Fatalf("TestEvaluatePodSpecServiceAccount returned service account %q for an object without name", result3.ServiceAccountName)
+	}
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	if sa := GenerateServiceAccount(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "web-"}}, &gvk, policy1); sa != nil {
+		t.Fatalf("TestEvaluatePodSpecServiceAccount generated %v for an object without name", sa)
+	}
+}
+
+func TestExemptions(t *testing.T) {
+	pol := policy.Policy{
+		Privileged: false,
+		Exemptions: []policy.Exemption{
+			{Namespace: "kube-system", Image: "docker.io/calico/*", Rules: []string{policy.RulePrivileged}},
+		},
+	}
+	privileged1, privileged2, privileged3 := true, true, true
+	container1 := corev1.Container{Name: "install-cni", Image: "docker.io/calico/cni:v3.27.0"}
+	container1.SecurityContext = &corev1.SecurityContext{Privileged: &privileged1}
+	container2 := corev1.Container{Name: "web", Image: "nginx"}
+	container2.SecurityContext = &corev1.SecurityContext{Privileged: &privileged2}
+	container3 := corev1.Container{Name: "sidecar", Image: "envoy"}
+	container3.SecurityContext = &corev1.SecurityContext{Privileged: &privileged3}
+
+	meta := metav1.ObjectMeta{
+		Name: "calico-node",
+		Namespace: "kube-system",
+		Annotations: map[string]string{ExemptAnnotation + ".sidecar": "privileged,capabilities-add"},
+	}
+	containers := []corev1.Container{container1, container2, container3}
+
+	result, output := assessPrivileged(containers, pol, newExemptions(meta, pol), nil)
+
+	if *result[0].SecurityContext.Privileged != true {
+		t.Fatalf("TestExemptions modified exempted container %v", result[0].Name)
+	}
+	if *result[1].SecurityContext.Privileged != false {
+		t.Fatalf("TestExemptions did not modify container %v", result[1].Name)
+	}
+	if *result[2].SecurityContext.Privileged != true {
+		t.Fatalf("TestExemptions modified exempted container %v", result[2].Name)
+	}
+	if len(output) != 3 {
+		t.Fatalf("TestExemptions returned %v", output)
+	}
+
+	if source := newExemptions(meta, pol).podExempt(policy.RulePrivileged); source != "" {
+		t.Fatalf("TestExemptions exempted pod level rule by %v", source)
+	}
+}
+
+type labelRule struct {
+	dependencies []string
+}
+
+func (r labelRule) ID() (string) { return "custom-label" }
+func (r labelRule) Description() (string) { return "test rule" }
+func (r labelRule) Dependencies() ([]string) { return r.dependencies }
+func (r labelRule) Check(ps *corev1.PodSpec, ctx *Context) ([]Finding) { return r.Fix(ps.DeepCopy(), ctx) }
+func (r labelRule) Fix(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+	ps.Hostname = ctx.Meta.Name
+	return []Finding{{Rule: r.ID(), Message: "hostname set"}}
+}
+
+func TestRegister(t *testing.T) {
+	saved := registry
+	defer func() { registry = saved }()
+
+	if err := Register(labelRule{dependencies: []string{"unknown"}}); err != nil {
+		t.Fatalf("TestRegister returned %v", err)
+	}
+	if _, err := Rules(); err == nil {
+		t.Fatalf("TestRegister accepted an unknown dependency")
+	}
+
+	registry = append([]Rule{labelRule{dependencies: []string{policy.RuleRunAsUser}}}, saved...)
+	if err := Register(labelRule{}); err == nil {
+		t.Fatalf("TestRegister accepted a duplicated rule")
+	}
+
+	rules, err := Rules()
+	if err != nil {
+		t.Fatalf("TestRegister returned %v", err)
+	}
+	position := map[string]int{}
+	for i, r := range(rules) {
+		position[r.ID()] = i
+	}
+	if position["custom-label"] != position[policy.RuleRunAsUser] + 1 {
+		t.Fatalf("TestRegister evaluated the custom rule at %v", position["custom-label"])
+	}
+	if position[policy.RuleAutomountServiceAccountToken] < position[policy.RuleDefaultServiceAccount] {
+		t.Fatalf("TestRegister evaluated %v before its dependency", policy.RuleAutomountServiceAccountToken)
+	}
+
+	pol := policy.Default()
+	pol.EnabledRules = []string{"custom-label", policy.RulePrivileged}
+	pol.DisabledRules = []string{policy.RulePrivileged}
+	result, output, err := evaluatePodSpec(corev1.PodSpec{}, metav1.ObjectMeta{Name: "web"}, pol)
+	if err != nil || result.Hostname != "web" || len(output) != 1 {
+		t.Fatalf("TestRegister returned %v, %v", output, err)
+	}
+}
+
+func TestCustomRules(t *testing.T) {
+	pol := policy.Default()
+	pol.CustomRules = []policy.CustomRule{
+		{
+			ID: "registry",
+			Scope: policy.ScopeContainer,
+			Expression: "container.image.startsWith('registry.corp/')",
+			Message: "image must come from registry.corp",
+			Severity: "high",
+		},
+		{
+			ID: "no-host-aliases",
+			Expression: "!has(podSpec.hostAliases)",
+			Patch: []policy.PatchOperation{{Op: "remove", Path: "/hostAliases"}},
+		},
+	}
+	podSpec := corev1.PodSpec{
+		HostAliases: []corev1.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"db"}}},
+		Containers: []corev1.Container{
+			{Name: "web", Image: "registry.corp/web:1.0"},
+			{Name: "sidecar", Image: "envoy"},
+			{Name: "debug", Image: "busybox"},
+		},
+	}
+	meta := metav1.ObjectMeta{Name: "web", Annotations: map[string]string{ExemptAnnotation + ".debug": "registry"}}
+
+	result, output, err := evaluatePodSpec(podSpec, meta, pol)
+
+	if err != nil {
+		t.Fatalf("TestCustomRules returned %v", err)
+	}
+	if result.HostAliases != nil {
+		t.Fatalf("TestCustomRules did not apply the patch: %v", result.HostAliases)
+	}
+	if len(output) != 3 {
+		t.Fatalf("TestCustomRules returned %v", output)
+	}
+	if output[0].Container != "sidecar" || output[0].Severity != "high" || output[1].Exempted != true {
+		t.Fatalf("TestCustomRules returned %v", output)
+	}
+}
+
+func TestEvaluatePodSpecUnchanged(t *testing.T) {
+	podSpec := corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}}
+	baseline, _ := policy.Builtin("baseline")
+
+	result, _, err := evaluatePodSpec(podSpec, metav1.ObjectMeta{Name: "web"}, baseline)
+
+	if err != nil || result.SecurityContext != nil {
+		t.Fatalf("TestEvaluatePodSpecUnchanged added a security context to a compliant pod: %v", err)
+	}
+}
+
+func TestHardenCustomResource(t *testing.T) {
+	pol, _ := policy.Builtin("restricted")
+	pol.DefaultServiceAccount = false
+	pol.Workloads = []policy.Workload{
+		{APIVersion: "keda.sh/v1alpha1", Kind: "ScaledJob", PodTemplates: []string{"spec.jobTargetRef.template"}, PodSpecs: []string{".spec.missing"}},
+	}
+	scaledJob := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "keda.sh/v1alpha1",
+		"kind": "ScaledJob",
+		"metadata": map[string]interface{}{"name": "worker", "namespace": "jobs"},
+		"spec": map[string]interface{}{
+			"pollingInterval": int64(30),
+			"jobTargetRef": map[string]interface{}{
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"annotations": map[string]interface{}{ExemptAnnotation: policy.RuleHostNetwork},
+					},
+					"spec": map[string]interface{}{
+						"hostNetwork": true,
+						"hostPID": true,
+						"extension": "kept",
+						"containers": []interface{}{
+							map[string]interface{}{"name": "worker", "image": "worker:1.0"},
+						},
+					},
+				},
+			},
+		},
+	}}
+	gvk := scaledJob.GroupVersionKind()
+
+	if !Supports(&gvk, pol) {
+		t.Fatalf("TestHardenCustomResource does not support %v", gvk)
+	}
+
+	hardened, output, err := GenerateHardenedObject(scaledJob, &gvk, pol)
+	if err != nil {
+		t.Fatalf("TestHardenCustomResource returned %v", err)
+	}
+	if len(output) == 0 {
+		t.Fatalf("TestHardenCustomResource returned no findings")
+	}
+	if err := VerifyIdempotent(hardened, &gvk, pol); err != nil {
+		t.Fatalf("TestHardenCustomResource returned %v", err)
+	}
+
+	u := hardened.(*unstructured.Unstructured)
+	spec, _, _ := unstructured.NestedMap(u.Object, "spec", "jobTargetRef", "template", "spec")
+	if spec["hostNetwork"] != true || spec["hostPID"] != nil || spec["extension"] != "kept" || spec["serviceAccountName"] != "worker" {
+		t.Fatalf("TestHardenCustomResource returned the pod spec %v", spec)
+	}
+	if interval, _, _ := unstructured.NestedInt64(u.Object, "spec", "pollingInterval"); interval != 30 {
+		t.Fatalf("TestHardenCustomResource modified the fields outside of the pod spec")
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(scaledJob.Object, "spec", "jobTargetRef", "template", "spec", "serviceAccountName"); found {
+		t.Fatalf("TestHardenCustomResource modified the original object")
+	}
+
+	serviceAccount, ok := GenerateServiceAccount(scaledJob, &gvk, pol).(*corev1.ServiceAccount)
+	if !ok || serviceAccount.Name != "worker" || serviceAccount.Namespace != "jobs" {
+		t.Fatalf("TestHardenCustomResource generated %v", serviceAccount)
+	}
+
+	unknown := schema.GroupVersionKind{Group: "argoproj.io", Version: "v1alpha1", Kind: "Rollout"}
+	if Supports(&unknown, pol) {
+		t.Fatalf("TestHardenCustomResource supports %v", unknown)
+	}
+}
+
+func TestSupportedKindOtherGroup(t *testing.T) {
+	pol, _ := policy.Builtin("restricted")
+	for _, gvk := range([]schema.GroupVersionKind{
+		{Group: "example.com", Version: "v1", Kind: "Deployment"},
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+		{Group: "example.com", Version: "v1", Kind: "Pod"},
+	}) {
+		if SupportedKind(&gvk) || Supports(&gvk, pol) {
+			t.Fatalf("TestSupportedKindOtherGroup supports %v", gvk)
+		}
+	}
+	for _, gvk := range([]schema.GroupVersionKind{{Group: "apps", Version: "v1", Kind: "Deployment"}, {Version: "v1", Kind: "Pod"}}) {
+		if !SupportedKind(&gvk) {
+			t.Fatalf("TestSupportedKindOtherGroup does not support %v", gvk)
+		}
+	}
+
+	// a custom Deployment listed in the Workloads is hardened as a workload, not as an apps/v1 Deployment
+	pol.Workloads = []policy.Workload{{APIVersion: "example.com/v1", Kind: "Deployment", PodSpecs: []string{"spec.pod"}}}
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{"name": "web"},
+		"spec": map[string]interface{}{
+			"pod": map[string]interface{}{
+				"hostNetwork": true,
+				"containers": []interface{}{map[string]interface{}{"name": "web", "image": "web:1.0"}},
+			},
+		},
+	}}
+	gvk := deployment.GroupVersionKind()
+	hardened, _, err := GenerateHardenedObject(deployment, &gvk, pol)
+	if err != nil {
+		t.Fatalf("TestSupportedKindOtherGroup returned %v", err)
+	}
+	if found, _, _ := unstructured.NestedBool(hardened.(*unstructured.Unstructured).Object, "spec", "pod", "hostNetwork"); found {
+		t.Fatalf("TestSupportedKindOtherGroup did not harden the custom Deployment")
+	}
+}
+
+func TestVerifyIdempotent(t *testing.T) {
+	documents, err := utils.ReadObjects("../../files/manifests/pod.yaml")
+	if err != nil {
+		t.Fatalf("TestVerifyIdempotent returned %v", err)
+	}
+	deployments, err := utils.ReadObjects("../../files/manifests/deployment.yaml")
+	if err != nil {
+		t.Fatalf("TestVerifyIdempotent returned %v", err)
+	}
+	documents = append(documents, deployments...)
+
+	custom, _ := policy.Builtin("restricted")
+	custom.CapabilitiesDrop = []string{"NET_RAW", "SYS_ADMIN"}
+	custom.CustomRules = []policy.CustomRule{{ID: "registry", Scope: policy.ScopeContainer, Expression: "container.image.startsWith('registry.corp/')"}}
+
+	// the seccomp profiles of custom.yaml can only be undefined
+	customFile, err := policy.Load("../../files/policies/custom.yaml")
+	if err != nil {
+		t.Fatalf("TestVerifyIdempotent returned %v", err)
+	}
+
+	for _, name := range([]string{"restricted", "baseline", "custom", "custom.yaml"}) {
+		pol, ok := policy.Builtin(name)
+		if name == "custom" {
+			pol = custom
+		} else if !ok {
+			pol = customFile
+		}
+		for _, d := range(documents) {
+			hardened, _, err := GenerateHardenedObject(d.Object, d.GVK, pol)
+			if err != nil {
+				t.Fatalf("TestVerifyIdempotent returned %v", err)
+			}
+			if err := VerifyIdempotent(hardened, d.GVK, pol); err != nil {
+				t.Fatalf("TestVerifyIdempotent failed for %v with the %v policy: %v", d.GVK.Kind, name, err)
+			}
+			again, _, _ := GenerateHardenedObject(d.Object, d.GVK, pol)
+			if !reflect.DeepEqual(hardened, again) {
+				t.Fatalf("TestVerifyIdempotent hardened %v differently on every run", d.GVK.Kind)
+			}
+		}
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{HostNetwork: true}}
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	restricted, _ := policy.Builtin("restricted")
+	if err := VerifyIdempotent(pod, &gvk, restricted); err == nil {
+		t.Fatalf("TestVerifyIdempotent accepted an object that is not hardened")
+	}
+}
+
+func TestAssessCapabilitiesDrop(t *testing.T) {
+	pol := policy.Policy{CapabilitiesDrop: []string{"ALL"}}
+	containers := []corev1.Container{
+		{Name: "none"},
+		{Name: "all", SecurityContext: &corev1.SecurityContext{Capabilities: &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}}},
+	}
+
+	result, output := assessCapabilitiesDrop(containers, pol, exemptions{}, nil)
+
+	if result[0].SecurityContext == nil || !utils.CapabilityInList(result[0].SecurityContext.Capabilities.Drop, "ALL") {
+		t.Fatalf("TestAssessCapabilitiesDrop did not drop the capabilities of a container without a security context")
+	}
+	if len(output) != 1 || output[0].Container != "none" {
+		t.Fatalf("TestAssessCapabilitiesDrop returned %v", output)
+	}
+}
+
+func TestAssessSeccompRuntimeDefault(t *testing.T) {
+	pol := policy.Policy{Seccomp: []string{"RuntimeDefault"}}
+	localhost := "profiles/web.json"
+	containers := []corev1.Container{
+		{Name: "localhost", SecurityContext: &corev1.SecurityContext{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: &localhost}}},
+		{Name: "unconfined", SecurityContext: &corev1.SecurityContext{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}}},
+	}
+
+	result, output := assessSeccomp(containers, pol, exemptions{}, nil)
+
+	// "Default" is not a valid seccomp profile type, the profiles are set to RuntimeDefault without a localhost profile
+	for _, c := range(result) {
+		profile := c.SecurityContext.SeccompProfile
+		if profile.Type != corev1.SeccompProfileTypeRuntimeDefault || profile.LocalhostProfile != nil {
+			t.Fatalf("TestAssessSeccompRuntimeDefault returned %v for %v", profile, c.Name)
+		}
+	}
+	if len(output) != 2 {
+		t.Fatalf("TestAssessSeccompRuntimeDefault returned %v", output)
+	}
+
+	ps, _, _ := evaluatePodSpec(corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}}, metav1.ObjectMeta{Name: "web"}, policy.Policy{Seccomp: []string{"RuntimeDefault"}, AllowedVolumes: []string{"*"}, DefaultServiceAccount: true, AutomountServiceAccountToken: true})
+	if ps.SecurityContext == nil || ps.SecurityContext.SeccompProfile == nil || ps.SecurityContext.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Fatalf("TestAssessSeccompRuntimeDefault returned the pod security context %v", ps.SecurityContext)
+	}
+}
+
+func TestAssessSeccompPolicyValues(t *testing.T) {
+	unconfined := func() ([]corev1.Container) {
+		return []corev1.Container{{Name: "web", SecurityContext: &corev1.SecurityContext{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}}}}
+	}
+
+	result, output := assessSeccomp(unconfined(), policy.Policy{Seccomp: []string{"Undefined"}}, exemptions{}, nil)
+	if result[0].SecurityContext.SeccompProfile != nil || len(output) != 1 {
+		t.Fatalf("TestAssessSeccompPolicyValues returned %v, %v when only undefined profiles are allowed", result[0].SecurityContext.SeccompProfile, output)
+	}
+
+	result, output = assessSeccomp(unconfined(), policy.Policy{Seccomp: []string{"Localhost"}}, exemptions{}, nil)
+	if result[0].SecurityContext.SeccompProfile.Type != corev1.SeccompProfileTypeUnconfined || len(output) != 1 {
+		t.Fatalf("TestAssessSeccompPolicyValues returned %v, %v when only Localhost profiles are allowed", result[0].SecurityContext.SeccompProfile, output)
+	}
+}
+
+func TestPrivilegedPolicyUnchanged(t *testing.T) {
+	pol, _ := policy.Builtin("privileged")
+	documents, err := utils.ReadObjects("../../files/manifests/pod.yaml")
+	if err != nil {
+		t.Fatalf("TestPrivilegedPolicyUnchanged returned %v", err)
+	}
+	deployments, err := utils.ReadObjects("../../files/manifests/deployment.yaml")
+	if err != nil {
+		t.Fatalf("TestPrivilegedPolicyUnchanged returned %v", err)
+	}
+
+	for _, d := range(append(documents, deployments...)) {
+		hardened, output, err := GenerateHardenedObject(d.Object, d.GVK, pol)
+		if err != nil || len(output) > 0 {
+			t.Fatalf("TestPrivilegedPolicyUnchanged returned %v, %v for %v", output, err, d.GVK.Kind)
+		}
+		if !reflect.DeepEqual(hardened, d.Object) || GenerateServiceAccount(d.Object, d.GVK, pol) != nil {
+			t.Fatalf("TestPrivilegedPolicyUnchanged modified %v", d.GVK.Kind)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	rules, _ := Rules()
+	for _, r := range(rules) {
+		e, err := Explain(r.ID(), policy.Policy{})
+		if err != nil || e.Risk == "" || e.Remediation == "" {
+			t.Fatalf("TestExplain returned %v, %v for %v", e, err, r.ID())
+		}
+	}
+
+	e, _ := Explain(policy.RuleCapabilitiesDrop, policy.Policy{})
+	if e.Control != "Capabilities (restricted)" || e.Annotation("web") != ExemptAnnotation + ".web: capabilities-drop" {
+		t.Fatalf("TestExplain returned %v", e)
+	}
+	e, _ = Explain(policy.RuleHostNetwork, policy.Policy{})
+	if e.Annotation("web") != ExemptAnnotation + ": host-network" || len(e.Exemptions()) != 2 {
+		t.Fatalf("TestExplain returned %v", e)
+	}
+
+	pol := policy.Policy{CustomRules: []policy.CustomRule{{ID: "registry", Description: "Images come from registry.corp", Scope: policy.ScopeContainer, Expression: "true"}}}
+	if e, err := Explain("registry", pol); err != nil || e.Description != "Images come from registry.corp" || !e.Containers {
+		t.Fatalf("TestExplain returned %v, %v for a custom rule", e, err)
+	}
+	if _, err := Explain("unknown", pol); err == nil {
+		t.Fatalf("TestExplain explained an unknown rule")
+	}
+}
+
+func TestSeverities(t *testing.T) {
+	pol, _ := policy.Builtin("restricted")
+	pol.Severities = map[string]string{policy.RuleSeccomp: "high"}
+	privileged := true
+	podSpec := corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "web", SecurityContext: &corev1.SecurityContext{Privileged: &privileged}},
+		{Name: "debug"},
+	}}
+	meta := metav1.ObjectMeta{Name: "web", Annotations: map[string]string{ExemptAnnotation + ".debug": policy.RuleCapabilitiesDrop}}
+
+	_, output, err := evaluatePodSpec(podSpec, meta, pol)
+	if err != nil {
+		t.Fatalf("TestSeverities returned %v", err)
+	}
+	expected := map[string]string{policy.RulePrivileged: "critical", policy.RuleSeccomp: "high", policy.RuleRunAsUser: "medium"}
+	for _, f := range(output) {
+		if f.Exempted && f.Severity != "" {
+			t.Fatalf("TestSeverities set the severity of an exemption: %v", f)
+		}
+		if s, ok := expected[f.Rule]; ok && f.Severity != s {
+			t.Fatalf("TestSeverities returned %v for %v", f.Severity, f.Rule)
+		}
+	}
+}
+
+func TestCustomRulesInvalidPatch(t *testing.T) {
+	pol := policy.Default()
+	pol.CustomRules = []policy.CustomRule{{
+		ID: "rename",
+		Scope: policy.ScopeContainer,
+		Expression: "false",
+		Patch: []policy.PatchOperation{{Op: "replace", Path: "/name", Value: 5}},
+	}}
+	podSpec := corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}}
+
+	result, _, err := evaluatePodSpec(podSpec, metav1.ObjectMeta{Name: "web"}, pol)
+
+	if err == nil {
+		t.Fatalf("TestCustomRulesInvalidPatch accepted a patch of the wrong type")
+	}
+	if result.Containers[0].Name != "web" || result.Containers[0].Image != "nginx" {
+		t.Fatalf("TestCustomRulesInvalidPatch modified the container: %v", result.Containers[0])
+	}
+}
@@ -0,0 +1,250 @@
+package generator
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+	"sync"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Finding is the result of a rule for a pod spec or one of its containers
+type Finding struct {
+	Rule string `yaml:"rule"`
+	Container string `yaml:"container,omitempty"` // empty for pod level findings
+	Message string `yaml:"message"`
+	Exempted bool `yaml:"exempted,omitempty"` // the rule was skipped because of an exemption
+	Severity string `yaml:"severity,omitempty"` // low, medium, high or critical, empty for exemptions
+}
+
+// Context is what a rule knows about the workload whose pod spec is evaluated
+type Context struct {
+	Meta metav1.ObjectMeta // metadata of the workload, including the annotations of its pod template
+	Policy policy.Policy
+	User int64 // non-root user assigned to the pod and its containers when RunAsUser is enforced, derived from the workload
+	exemptions exemptions
+	err error // first error of the rules, which fails the evaluation of the pod spec
+}
+
+func newContext(meta metav1.ObjectMeta, pol policy.Policy) (*Context) {
+	return &Context{Meta: meta, Policy: pol, User: utils.StableUser(meta.Namespace, meta.Name), exemptions: newExemptions(meta, pol)}
+}
+
+// fail records an error of a rule. Hardening the object fails with the first one
+func (c *Context) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// PodExempt returns the source of the exemption of a pod level rule, or "" if the rule applies
+func (c *Context) PodExempt(rule string) (string) {
+	return c.exemptions.podExempt(rule)
+}
+
+// ContainerExempt returns the source of the exemption of a container level rule, or "" if the rule applies
+func (c *Context) ContainerExempt(rule string, container corev1.Container) (string) {
+	return c.exemptions.containerExempt(rule, container)
+}
+
+// Rule is a security control evaluated against the pod spec of every hardened object
+type Rule interface {
+	// ID is the name used in policies, exemptions and findings, e.g. "privileged"
+	ID() string
+	Description() string
+	// Dependencies returns the IDs of the rules that must be evaluated before this one
+	Dependencies() []string
+	// Check returns the findings of the rule without modifying the pod spec
+	Check(ps *corev1.PodSpec, ctx *Context) []Finding
+	// Fix remediates the pod spec and returns what was changed
+	Fix(ps *corev1.PodSpec, ctx *Context) []Finding
+}
+
+type fixFunc func(ps *corev1.PodSpec, ctx *Context) ([]Finding)
+
+type assessFunc func(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding)
+
+// builtinRule is a rule whose check is its remediation applied to a copy of the pod spec
+type builtinRule struct {
+	id string
+	description string
+	dependencies []string
+	fix fixFunc
+}
+
+func (r builtinRule) ID() (string) {
+	return r.id
+}
+
+func (r builtinRule) Description() (string) {
+	return r.description
+}
+
+func (r builtinRule) Dependencies() ([]string) {
+	return r.dependencies
+}
+
+func (r builtinRule) Check(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+	return r.fix(ps.DeepCopy(), ctx)
+}
+
+func (r builtinRule) Fix(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+	return r.fix(ps, ctx)
+}
+
+// registry holds the registered rules in registration order, guarded by registryMu
+var (
+	registry []Rule
+	registryMu sync.RWMutex
+)
+
+// Register adds a rule to the ones evaluated for every hardened object. IDs must be unique.
+// It can be called concurrently with the evaluation of rules
+func Register(r Rule) (error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, existing := range(registry) {
+		if existing.ID() == r.ID() {
+			return fmt.Errorf("rule %v is already registered", r.ID())
+		}
+	}
+	registry = append(registry, r)
+	policy.AddRuleID(r.ID())
+	return nil
+}
+
+// Rules returns the registered rules in evaluation order: every rule comes after its dependencies,
+// otherwise rules keep their registration order
+func Rules() ([]Rule, error) {
+	registryMu.RLock()
+	registry := append([]Rule{}, registry...)
+	registryMu.RUnlock()
+
+	byID := map[string]Rule{}
+	for _, r := range(registry) {
+		byID[r.ID()] = r
+	}
+	for _, r := range(registry) {
+		for _, dep := range(r.Dependencies()) {
+			if _, ok := byID[dep]; !ok {
+				return nil, fmt.Errorf("rule %v depends on unknown rule %v", r.ID(), dep)
+			}
+		}
+	}
+
+	var result []Rule
+	done := map[string]bool{}
+	for len(result) < len(registry) {
+		progress := false
+		for _, r := range(registry) {
+			if done[r.ID()] || !dependenciesDone(r, done) {
+				continue
+			}
+			result = append(result, r)
+			done[r.ID()] = true
+			progress = true
+			// restart from the first rule to keep the registration order
+			break
+		}
+		if !progress {
+			var pending []string
+			for _, r := range(registry) {
+				if !done[r.ID()] {
+					pending = append(pending, r.ID())
+				}
+			}
+			return nil, fmt.Errorf("rules %v have cyclic dependencies", pending)
+		}
+	}
+	return result, nil
+}
+
+func dependenciesDone(r Rule, done map[string]bool) (bool) {
+	for _, dep := range(r.Dependencies()) {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// EnabledRules returns the rules evaluated with the policy, in evaluation order.
+// The custom rules of the policy are evaluated after the registered ones
+func EnabledRules(pol policy.Policy) ([]Rule, error) {
+	rules, err := Rules()
+	if err != nil {
+		return nil, err
+	}
+	for _, custom := range(pol.CustomRules) {
+		rule, err := newCELRule(custom)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	var result []Rule
+	for _, r := range(rules) {
+		if pol.RuleEnabled(r.ID()) {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+// containerFix applies a container level assessment to the containers and init containers
+func containerFix(assess assessFunc) (fixFunc) {
+	return func(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+		ps.Containers, output = assess(ps.Containers, ctx.Policy, ctx.exemptions, output)
+		if ps.InitContainers != nil {
+			ps.InitContainers, output = assess(ps.InitContainers, ctx.Policy, ctx.exemptions, output)
+		}
+		return output
+	}
+}
+
+// chain applies the pod level and container level parts of a rule in order
+func chain(fixes ...fixFunc) (fixFunc) {
+	return func(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+		var output []Finding
+		for _, fix := range(fixes) {
+			output = append(output, fix(ps, ctx)...)
+		}
+		return output
+	}
+}
+
+func init() {
+	builtins := []builtinRule{
+		{id: policy.RuleHostPID, description: "Pods must not share the host PID namespace", fix: assessHostPID},
+		{id: policy.RuleHostNetwork, description: "Pods must not use the host network", fix: assessHostNetwork},
+		{id: policy.RuleHostIPC, description: "Pods must not share the host IPC namespace", fix: assessHostIPC},
+		{id: policy.RuleVolumes, description: "Only allowed volume types can be mounted", fix: assessVolumes},
+		{id: policy.RuleHostProcess, description: "Windows pods and containers must not run as host processes",
+			fix: chain(assessPodHostProcess, containerFix(assessHostProcess))},
+		{id: policy.RulePrivileged, description: "Containers must not be privileged", fix: containerFix(assessPrivileged)},
+		{id: policy.RuleCapabilitiesAdd, description: "Containers can only add allowed capabilities", fix: containerFix(assessCapabilitiesAdd)},
+		{id: policy.RuleCapabilitiesDrop, description: "Containers must drop the required capabilities", fix: containerFix(assessCapabilitiesDrop)},
+		{id: policy.RuleProcMount, description: "Containers must use the allowed proc mount type", fix: containerFix(assessProcMount)},
+		{id: policy.RuleSeccomp, description: "Pods and containers must use an allowed seccomp profile",
+			fix: chain(assessPodSeccomp, containerFix(assessSeccomp))},
+		{id: policy.RuleAllowPrivilegeEscalation, description: "Containers must not allow privilege escalation", fix: containerFix(assessAllowPrivilegeEscalation)},
+		{id: policy.RuleRunAsNonRoot, description: "Pods and containers must run as non-root",
+			fix: chain(assessPodRunAsNonRoot, containerFix(assessRunAsNonRoot))},
+		{id: policy.RuleRunAsUser, description: "Pods and containers must not run with user ID 0",
+			fix: chain(assessPodRunAsUser, func(ps *corev1.PodSpec, ctx *Context) ([]Finding) {
+				return containerFix(func(containers []corev1.Container, pol policy.Policy, ex exemptions, output []Finding) ([]corev1.Container, []Finding) {
+					return assessRunAsUser(containers, pol, ex, ctx.User, output)
+				})(ps, ctx)
+			})},
+		{id: policy.RuleDefaultServiceAccount, description: "Pods must not use the default service account", fix: assessDefaultServiceAccount},
+		// the token exemptions apply to the service account set by the default-service-account rule
+		{id: policy.RuleAutomountServiceAccountToken, description: "Pods must not mount the service account token",
+			dependencies: []string{policy.RuleDefaultServiceAccount}, fix: assessAutomountServiceAccountToken},
+	}
+	for _, r := range(builtins) {
+		if err := Register(r); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package generator
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"fmt"
+	"reflect"
+	"strings"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Supports returns true if objects of the given kind are hardened with the policy: the built-in kinds,
+// and the kinds listed in its Workloads, typed (e.g. CronJob) or unstructured (custom resources)
+func Supports(gVK *schema.GroupVersionKind, pol policy.Policy) (bool) {
+	if SupportedKind(gVK) {
+		return true
+	}
+	_, ok := pol.Workload(gVK.GroupVersion().String(), gVK.Kind)
+	return ok
+}
+
+// embeddedPodSpec is a pod spec found at one of the paths of a workload
+type embeddedPodSpec struct {
+	path []string // path of the PodSpec field
+	content map[string]interface{}
+	spec corev1.PodSpec
+	meta metav1.ObjectMeta // metadata of the workload, including the annotations of the pod template
+}
+
+// asUnstructured returns the object if it is unstructured, or its unstructured copy
+func asUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u, nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
+// embeddedPodSpecs returns the pod specs of a workload, in the order of the configured paths.
+// Paths that are not set in the object are skipped
+func embeddedPodSpecs(obj runtime.Object, w policy.Workload) ([]embeddedPodSpec, error) {
+	u, err := asUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name: u.GetName(),
+		Namespace: u.GetNamespace(),
+		Labels: u.GetLabels(),
+		Annotations: u.GetAnnotations(),
+	}
+
+	var result []embeddedPodSpec
+	for _, path := range(w.PodTemplates) {
+		fields := policy.SplitPath(path)
+		content, found, err := unstructured.NestedMap(u.Object, fields...)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the pod template %v of %v %v:\n%s", path, u.GetKind(), u.GetName(), err)
+		}
+		if !found {
+			continue
+		}
+		var template corev1.PodTemplateSpec
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &template); err != nil {
+			return nil, fmt.Errorf("Error reading the pod template %v of %v %v:\n%s", path, u.GetKind(), u.GetName(), err)
+		}
+		specContent, _, _ := unstructured.NestedMap(content, "spec")
+		result = append(result, embeddedPodSpec{
+			path: append(fields, "spec"),
+			content: specContent,
+			spec: template.Spec,
+			meta: workloadMeta(objectMeta, template.ObjectMeta),
+		})
+	}
+	for _, path := range(w.PodSpecs) {
+		fields := policy.SplitPath(path)
+		content, found, err := unstructured.NestedMap(u.Object, fields...)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the pod spec %v of %v %v:\n%s", path, u.GetKind(), u.GetName(), err)
+		}
+		if !found {
+			continue
+		}
+		var spec corev1.PodSpec
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, &spec); err != nil {
+			return nil, fmt.Errorf("Error reading the pod spec %v of %v %v:\n%s", path, u.GetKind(), u.GetName(), err)
+		}
+		result = append(result, embeddedPodSpec{path: fields, content: content, spec: spec, meta: objectMeta})
+	}
+	return result, nil
+}
+
+// hardenWorkload evaluates every pod spec of a workload configured in the policy and writes the hardened
+// pod specs back into a copy of the object, through its unstructured content. Fields of the pod specs that
+// are not part of corev1.PodSpec are kept. Typed objects, e.g. a CronJob, are returned with their type
+func hardenWorkload(obj runtime.Object, w policy.Workload, pol policy.Policy) (runtime.Object, []Finding, error) {
+	podSpecs, err := embeddedPodSpecs(obj, w)
+	if err != nil {
+		return obj, nil, err
+	}
+
+	u, err := asUnstructured(obj.DeepCopyObject())
+	if err != nil {
+		return obj, nil, err
+	}
+	var output []Finding
+	for _, ps := range(podSpecs) {
+		hardened, findings, err := evaluatePodSpec(ps.spec, ps.meta, pol)
+		if err != nil {
+			return obj, output, err
+		}
+		output = append(output, findings...)
+
+		content, err := utils.ToUnstructured(&hardened, ps.content)
+		if err != nil {
+			return obj, output, err
+		}
+		for k, v := range(ps.content) {
+			if _, ok := content[k]; !ok && !podSpecFields[k] {
+				content[k] = v
+			}
+		}
+		if err := unstructured.SetNestedMap(u.Object, content, ps.path...); err != nil {
+			return obj, output, err
+		}
+	}
+
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		return u, output, nil
+	}
+	newObject := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, newObject); err != nil {
+		return obj, output, err
+	}
+	return newObject, output, nil
+}
+
+// podSpecFields are the JSON names of the fields of corev1.PodSpec
+var podSpecFields = func() (map[string]bool) {
+	result := map[string]bool{}
+	t := reflect.TypeOf(corev1.PodSpec{})
+	for i := 0; i < t.NumField(); i++ {
+		result[strings.Split(t.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	return result
+}()
@@ -0,0 +1,316 @@
+package importer
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const seccompAllowedProfilesAnnotation = "seccomp.security.alpha.kubernetes.io/allowedProfileNames"
+
+// pspFields are the PodSecurityPolicy spec fields that are translated into the policy
+var pspFields = []string{
+	"privileged", "hostPID", "hostIPC", "hostNetwork", "allowedCapabilities", "requiredDropCapabilities",
+	"volumes", "allowPrivilegeEscalation", "runAsUser", "allowedProcMountTypes",
+}
+
+// Import translates PodSecurityPolicies (policy/v1beta1) and Gatekeeper K8sPSP* constraints into a policy.
+// Every document restricts the privileged policy further, as if all of them applied. It returns the
+// settings that can't be represented in the policy
+func Import(r io.Reader) (policy.Policy, []string, error) {
+	pol, _ := policy.Builtin("privileged")
+	var unsupported []string
+	found := 0
+
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		data, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return pol, unsupported, err
+		}
+
+		obj := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &obj); err != nil {
+			return pol, unsupported, err
+		}
+		if len(obj) == 0 {
+			continue
+		}
+
+		u := unstructured.Unstructured{Object: obj}
+		name := fmt.Sprintf("%v %v", u.GetKind(), u.GetName())
+
+		switch {
+			case u.GetKind() == "PodSecurityPolicy":
+				unsupported = append(unsupported, importPSP(&pol, u, name)...)
+			case strings.HasPrefix(u.GetKind(), "K8sPSP"):
+				unsupported = append(unsupported, importConstraint(&pol, u, name)...)
+			default:
+				unsupported = append(unsupported, fmt.Sprintf("%v: unsupported kind, ignored", name))
+				continue
+		}
+		found++
+	}
+
+	if found == 0 {
+		return pol, unsupported, fmt.Errorf("No PodSecurityPolicy or K8sPSP constraint found")
+	}
+
+	return pol, unsupported, nil
+}
+
+func importPSP(pol *policy.Policy, u unstructured.Unstructured, name string) ([]string) {
+	var unsupported []string
+	spec, _, _ := unstructured.NestedMap(u.Object, "spec")
+
+	restrictBool(&pol.Privileged, spec, "privileged", false)
+	restrictBool(&pol.HostPID, spec, "hostPID", false)
+	restrictBool(&pol.HostIPC, spec, "hostIPC", false)
+	restrictBool(&pol.HostNetwork, spec, "hostNetwork", false)
+	restrictBool(&pol.AllowPrivilegeEscalation, spec, "allowPrivilegeEscalation", true)
+
+	caps, _ := stringSlice(spec, "allowedCapabilities")
+	pol.CapabilitiesAdd = restrictList(pol.CapabilitiesAdd, importCapabilities(caps), "ALL")
+	if caps, ok := stringSlice(spec, "requiredDropCapabilities"); ok {
+		pol.CapabilitiesDrop = appendUnique(pol.CapabilitiesDrop, importCapabilities(caps)...)
+	}
+
+	volumes, _ := stringSlice(spec, "volumes")
+	allowedVolumes, errs := importVolumes(volumes)
+	pol.AllowedVolumes = restrictList(pol.AllowedVolumes, allowedVolumes, "*")
+	unsupported = append(unsupported, prefix(name, errs)...)
+
+	rule, _, _ := unstructured.NestedString(spec, "runAsUser", "rule")
+	unsupported = append(unsupported, prefix(name, importRunAsUser(pol, rule, spec["runAsUser"]))...)
+
+	// only the Default proc mount type is allowed unless Unmasked is listed
+	if procMounts, _ := stringSlice(spec, "allowedProcMountTypes"); !utils.ContainsValue(procMounts, "Unmasked") {
+		pol.ProcMount = "Default"
+	}
+
+	// without the annotation, pods can't set a seccomp profile
+	if profiles, ok := u.GetAnnotations()[seccompAllowedProfilesAnnotation]; ok {
+		restrictSeccomp(pol, importSeccomp(strings.Split(profiles, ",")))
+	} else {
+		restrictSeccomp(pol, []string{"Undefined"})
+	}
+
+	var ignored []string
+	for field := range(spec) {
+		if !utils.ContainsValue(pspFields, field) {
+			ignored = append(ignored, field)
+		}
+	}
+	for annotation := range(u.GetAnnotations()) {
+		if annotation != seccompAllowedProfilesAnnotation && strings.Contains(annotation, "kubernetes.io/") {
+			ignored = append(ignored, "annotation " + annotation)
+		}
+	}
+	sort.Strings(ignored)
+	for _, field := range(ignored) {
+		unsupported = append(unsupported, fmt.Sprintf("%v: %v can't be represented", name, field))
+	}
+
+	return unsupported
+}
+
+func importConstraint(pol *policy.Policy, u unstructured.Unstructured, name string) ([]string) {
+	var unsupported []string
+	params, _, _ := unstructured.NestedMap(u.Object, "spec", "parameters")
+
+	switch u.GetKind() {
+		case "K8sPSPPrivilegedContainer":
+			pol.Privileged = false
+		case "K8sPSPHostNamespace":
+			pol.HostPID = false
+			pol.HostIPC = false
+		case "K8sPSPHostNetworkingPorts":
+			restrictBool(&pol.HostNetwork, params, "hostNetwork", false)
+			if _, ok := params["min"]; ok {
+				unsupported = append(unsupported, fmt.Sprintf("%v: host port ranges can't be represented", name))
+			}
+		case "K8sPSPCapabilities":
+			if caps, ok := stringSlice(params, "allowedCapabilities"); ok {
+				pol.CapabilitiesAdd = restrictList(pol.CapabilitiesAdd, importCapabilities(caps), "ALL")
+			}
+			if caps, ok := stringSlice(params, "requiredDropCapabilities"); ok {
+				pol.CapabilitiesDrop = appendUnique(pol.CapabilitiesDrop, importCapabilities(caps)...)
+			}
+		case "K8sPSPVolumeTypes":
+			if volumes, ok := stringSlice(params, "volumes"); ok {
+				allowedVolumes, errs := importVolumes(volumes)
+				pol.AllowedVolumes = restrictList(pol.AllowedVolumes, allowedVolumes, "*")
+				unsupported = append(unsupported, prefix(name, errs)...)
+			}
+		case "K8sPSPAllowPrivilegeEscalationContainer":
+			pol.AllowPrivilegeEscalation = false
+		case "K8sPSPAllowedUsers":
+			rule, _, _ := unstructured.NestedString(params, "runAsUser", "rule")
+			unsupported = append(unsupported, prefix(name, importRunAsUser(pol, rule, params["runAsUser"]))...)
+			for _, field := range([]string{"runAsGroup", "supplementalGroups", "fsGroup"}) {
+				if _, ok := params[field]; ok {
+					unsupported = append(unsupported, fmt.Sprintf("%v: %v can't be represented", name, field))
+				}
+			}
+		case "K8sPSPProcMount":
+			if procMount, _, _ := unstructured.NestedString(params, "procMount"); procMount == "Default" {
+				pol.ProcMount = "Default"
+			}
+		case "K8sPSPSeccomp":
+			if profiles, ok := stringSlice(params, "allowedProfiles"); ok {
+				restrictSeccomp(pol, importSeccomp(profiles))
+			}
+			if _, ok := params["allowedLocalhostFiles"]; ok {
+				unsupported = append(unsupported, fmt.Sprintf("%v: allowedLocalhostFiles can't be represented", name))
+			}
+		default:
+			unsupported = append(unsupported, fmt.Sprintf("%v: constraint can't be represented", name))
+	}
+
+	if _, ok := params["exemptImages"]; ok {
+		unsupported = append(unsupported, fmt.Sprintf("%v: exemptImages can't be represented, the constraint applies to every image", name))
+	}
+	if _, ok, _ := unstructured.NestedMap(u.Object, "spec", "match"); ok {
+		unsupported = append(unsupported, fmt.Sprintf("%v: match can't be represented, the policy applies to every object", name))
+	}
+
+	return unsupported
+}
+
+// importRunAsUser translates the runAsUser strategy, returning the settings that can't be represented
+func importRunAsUser(pol *policy.Policy, rule string, settings interface{}) ([]string) {
+	switch rule {
+		case "MustRunAsNonRoot":
+			pol.RunAsNonRoot = true
+		case "MustRunAs":
+			pol.RunAsNonRoot = true
+			pol.RunAsUser = true
+			return []string{fmt.Sprintf("runAsUser ranges can't be represented, a non-root user derived from the namespace and name of each workload is assigned instead: %v", settings)}
+	}
+	return nil
+}
+
+// restrictBool sets a boolean field of the policy to the value of the spec, or to the default value if unset
+func restrictBool(field *bool, spec map[string]interface{}, key string, defaultValue bool) {
+	value, ok, _ := unstructured.NestedBool(spec, key)
+	if !ok {
+		value = defaultValue
+	}
+	*field = *field && value
+}
+
+func stringSlice(spec map[string]interface{}, key string) ([]string, bool) {
+	value, ok, err := unstructured.NestedStringSlice(spec, key)
+	return value, ok && err == nil
+}
+
+func importCapabilities(caps []string) ([]string) {
+	result := []string{}
+	for _, c := range(caps) {
+		c = strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+		if c == "*" {
+			c = "ALL"
+		}
+		result = appendUnique(result, c)
+	}
+	return result
+}
+
+// importVolumes translates the PSP volume names (the JSON names of corev1.VolumeSource) into volume types
+func importVolumes(volumes []string) ([]string, []string) {
+	result := []string{}
+	var unsupported []string
+	t := reflect.TypeOf(corev1.VolumeSource{})
+
+	for _, v := range(volumes) {
+		if v == "*" {
+			return []string{"*"}, nil
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == v {
+				result = appendUnique(result, t.Field(i).Name)
+				found = true
+			}
+		}
+		if !found && v != "none" {
+			unsupported = append(unsupported, fmt.Sprintf("volume type %v can't be represented", v))
+		}
+	}
+	return result, unsupported
+}
+
+func importSeccomp(profiles []string) ([]string) {
+	result := []string{}
+	for _, p := range(profiles) {
+		p = strings.TrimSpace(p)
+		switch {
+			case p == "*":
+				return []string{"RuntimeDefault", "Localhost", "Unconfined", "Undefined"}
+			case p == "runtime/default" || p == "docker/default" || p == "RuntimeDefault":
+				result = appendUnique(result, "RuntimeDefault")
+			case strings.HasPrefix(p, "localhost/") || p == "Localhost":
+				result = appendUnique(result, "Localhost")
+			case p == "unconfined" || p == "Unconfined":
+				result = appendUnique(result, "Unconfined")
+		}
+	}
+	return result
+}
+
+// restrictSeccomp keeps the seccomp types allowed by both the policy and the imported profiles
+func restrictSeccomp(pol *policy.Policy, allowed []string) {
+	result := []string{}
+	for _, s := range(pol.Seccomp) {
+		if utils.ContainsValue(allowed, s) {
+			result = append(result, s)
+		}
+	}
+	pol.Seccomp = result
+}
+
+// restrictList keeps the values allowed by both the policy and the imported document, so that a looser
+// document doesn't widen a stricter one. The wildcard (ALL capabilities, * volumes) allows every value
+func restrictList(current []string, imported []string, wildcard string) ([]string) {
+	if utils.ContainsValue(current, wildcard) {
+		return imported
+	}
+	if utils.ContainsValue(imported, wildcard) {
+		return current
+	}
+	result := []string{}
+	for _, v := range(current) {
+		if utils.ContainsValue(imported, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func appendUnique(slice []string, values ...string) ([]string) {
+	for _, v := range(values) {
+		if !utils.ContainsValue(slice, v) {
+			slice = append(slice, v)
+		}
+	}
+	return slice
+}
+
+func prefix(name string, messages []string) ([]string) {
+	var result []string
+	for _, m := range(messages) {
+		result = append(result, name + ": " + m)
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package importer
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"strings"
+	"testing"
+	"gopkg.in/yaml.v3"
+)
+
+const psp = `apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: restricted
+  annotations:
+    seccomp.security.alpha.kubernetes.io/allowedProfileNames: 'docker/default,runtime/default'
+spec:
+  privileged: false
+  allowPrivilegeEscalation: false
+  requiredDropCapabilities: [ALL]
+  volumes: [configMap, emptyDir, secret, downwardAPI, csi, persistentVolumeClaim]
+  hostNetwork: false
+  hostIPC: false
+  hostPID: false
+  runAsUser:
+    rule: MustRunAsNonRoot
+  seLinux:
+    rule: RunAsAny
+  readOnlyRootFilesystem: true
+`
+
+const constraints = `apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sPSPCapabilities
+metadata:
+  name: capabilities
+spec:
+  match:
+    kinds:
+    - apiGroups: [""]
+      kinds: ["Pod"]
+  parameters:
+    allowedCapabilities: ["NET_BIND_SERVICE", "CAP_NET_ADMIN"]
+    requiredDropCapabilities: ["ALL"]
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sPSPHostNamespace
+metadata:
+  name: host-namespace
+---
+apiVersion: constraints.gatekeeper.sh/v1beta1
+kind: K8sPSPReadOnlyRootFilesystem
+metadata:
+  name: read-only
+`
+
+func TestImportPSP(t *testing.T) {
+	pol, unsupported, err := Import(strings.NewReader(psp))
+	if err != nil {
+		t.Fatalf("TestImportPSP returned %v", err)
+	}
+
+	if pol.Privileged || pol.HostNetwork || pol.HostPID || pol.HostIPC || pol.AllowPrivilegeEscalation || !pol.RunAsNonRoot {
+		t.Fatalf("TestImportPSP returned %+v", pol)
+	}
+	if len(pol.CapabilitiesAdd) != 0 || len(pol.CapabilitiesDrop) != 1 || pol.CapabilitiesDrop[0] != "ALL" {
+		t.Fatalf("TestImportPSP returned capabilities %v %v", pol.CapabilitiesAdd, pol.CapabilitiesDrop)
+	}
+	if strings.Join(pol.AllowedVolumes, ",") != "ConfigMap,EmptyDir,Secret,DownwardAPI,CSI,PersistentVolumeClaim" {
+		t.Fatalf("TestImportPSP returned volumes %v", pol.AllowedVolumes)
+	}
+	if len(pol.Seccomp) != 1 || pol.Seccomp[0] != "RuntimeDefault" || pol.ProcMount != "Default" {
+		t.Fatalf("TestImportPSP returned seccomp %v and procMount %v", pol.Seccomp, pol.ProcMount)
+	}
+	if len(unsupported) != 2 || !strings.Contains(unsupported[0], "readOnlyRootFilesystem") || !strings.Contains(unsupported[1], "seLinux") {
+		t.Fatalf("TestImportPSP returned unsupported %v", unsupported)
+	}
+
+	// the imported policy must be a valid policy file
+	data, _ := yaml.Marshal(pol)
+	if _, err := policy.Parse(data); err != nil {
+		t.Fatalf("TestImportPSP generated an invalid policy: %v", err)
+	}
+}
+
+func TestImportConstraints(t *testing.T) {
+	pol, unsupported, err := Import(strings.NewReader(constraints))
+	if err != nil {
+		t.Fatalf("TestImportConstraints returned %v", err)
+	}
+
+	if strings.Join(pol.CapabilitiesAdd, ",") != "NET_BIND_SERVICE,NET_ADMIN" || pol.CapabilitiesDrop[0] != "ALL" {
+		t.Fatalf("TestImportConstraints returned capabilities %v %v", pol.CapabilitiesAdd, pol.CapabilitiesDrop)
+	}
+	if pol.HostPID || pol.HostIPC || !pol.HostNetwork || !pol.Privileged {
+		t.Fatalf("TestImportConstraints returned %+v", pol)
+	}
+	if len(unsupported) != 2 || !strings.Contains(unsupported[0], "match") || !strings.Contains(unsupported[1], "K8sPSPReadOnlyRootFilesystem") {
+		t.Fatalf("TestImportConstraints returned unsupported %v", unsupported)
+	}
+}
+
+func TestImportNothing(t *testing.T) {
+	if _, _, err := Import(strings.NewReader("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: test\n")); err == nil {
+		t.Fatalf("TestImportNothing did not fail")
+	}
+}
+
+const loosePSP = `apiVersion: policy/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: loose
+  annotations:
+    seccomp.security.alpha.kubernetes.io/allowedProfileNames: '*'
+spec:
+  allowedCapabilities: ['*']
+  volumes: ['*']
+`
+
+func TestImportLooserPSP(t *testing.T) {
+	pol, _, err := Import(strings.NewReader(psp + "---\n" + loosePSP))
+	if err != nil {
+		t.Fatalf("TestImportLooserPSP returned %v", err)
+	}
+	// a later, looser PSP doesn't widen the earlier one
+	if len(pol.CapabilitiesAdd) != 0 || strings.Join(pol.AllowedVolumes, ",") != "ConfigMap,EmptyDir,Secret,DownwardAPI,CSI,PersistentVolumeClaim" || strings.Join(pol.Seccomp, ",") != "RuntimeDefault" {
+		t.Fatalf("TestImportLooserPSP returned %v, %v, %v", pol.CapabilitiesAdd, pol.AllowedVolumes, pol.Seccomp)
+	}
+
+	pol, _, err = Import(strings.NewReader(strings.Replace(loosePSP, "['*']\n  volumes", "[NET_ADMIN, CHOWN]\n  volumes", 1) + "---\napiVersion: constraints.gatekeeper.sh/v1beta1\nkind: K8sPSPCapabilities\nmetadata:\n  name: capabilities\nspec:\n  parameters:\n    allowedCapabilities: [CHOWN, SYS_TIME]\n"))
+	if err != nil {
+		t.Fatalf("TestImportLooserPSP returned %v", err)
+	}
+	if strings.Join(pol.CapabilitiesAdd, ",") != "CHOWN" || strings.Join(pol.AllowedVolumes, ",") != "*" {
+		t.Fatalf("TestImportLooserPSP returned %v, %v", pol.CapabilitiesAdd, pol.AllowedVolumes)
+	}
+}
+
+func TestImportSeccomp(t *testing.T) {
+	// constraints don't restrict seccomp unless they are K8sPSPSeccomp constraints
+	pol, _, _ := Import(strings.NewReader(constraints))
+	if len(pol.Seccomp) != 4 {
+		t.Fatalf("TestImportSeccomp returned %v for constraints", pol.Seccomp)
+	}
+
+	// a PSP without the allowedProfileNames annotation only allows pods without a profile
+	pol, _, _ = Import(strings.NewReader("apiVersion: policy/v1beta1\nkind: PodSecurityPolicy\nmetadata:\n  name: none\nspec: {}\n"))
+	if strings.Join(pol.Seccomp, ",") != "Undefined" {
+		t.Fatalf("TestImportSeccomp returned %v for a PSP without annotation", pol.Seccomp)
+	}
+}
+
+func TestImportExemptImages(t *testing.T) {
+	_, unsupported, err := Import(strings.NewReader("apiVersion: constraints.gatekeeper.sh/v1beta1\nkind: K8sPSPPrivilegedContainer\nmetadata:\n  name: privileged\nspec:\n  parameters:\n    exemptImages: [\"docker.io/calico/*\"]\n"))
+	if err != nil {
+		t.Fatalf("TestImportExemptImages returned %v", err)
+	}
+	if len(unsupported) != 1 || !strings.Contains(unsupported[0], "exemptImages") {
+		t.Fatalf("TestImportExemptImages returned unsupported %v", unsupported)
+	}
+}
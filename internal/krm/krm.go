@@ -0,0 +1,277 @@
+package krm
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Annotations set by kustomize and kpt with the file of each item and its position in the file
+const (
+	pathAnnotation = "config.kubernetes.io/path"
+	internalPathAnnotation = "internal.config.kubernetes.io/path"
+	indexAnnotation = "config.kubernetes.io/index"
+	internalIndexAnnotation = "internal.config.kubernetes.io/index"
+)
+
+// ResourceList is the input and output of a KRM function
+type ResourceList struct {
+	APIVersion string `json:"apiVersion"`
+	Kind string `json:"kind"`
+	Items []map[string]interface{} `json:"items"`
+	FunctionConfig map[string]interface{} `json:"functionConfig,omitempty"`
+	Results []Result `json:"results,omitempty"`
+}
+
+// Result is a message of the function about an item
+type Result struct {
+	Message string `json:"message"`
+	Severity string `json:"severity"` // error, warning or info
+	ResourceRef *ResourceRef `json:"resourceRef,omitempty"`
+	File *File `json:"file,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type ResourceRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type File struct {
+	Path string `json:"path"`
+}
+
+// Run hardens the items of a serialized ResourceList with the policy of its functionConfig. It returns the
+// ResourceList with the hardened items and a result for every finding. If the function fails, the
+// output contains the error as a result, and the error is returned as well
+func Run(input []byte) ([]byte, error) {
+	var list ResourceList
+	if err := yaml.Unmarshal(input, &list); err != nil {
+		return nil, err
+	}
+	if list.Kind != "ResourceList" {
+		return nil, fmt.Errorf("Expected a ResourceList, got %q", list.Kind)
+	}
+
+	err := Process(&list)
+	if err != nil {
+		list.Results = append(list.Results, Result{Message: err.Error(), Severity: "error"})
+	}
+
+	output, marshalErr := yaml.Marshal(list)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	return output, err
+}
+
+// Process hardens the items of the ResourceList in place, appending the findings to its results.
+// Items that are not workloads, or that can't be decoded, are kept unchanged
+func Process(list *ResourceList) (error) {
+	pol, err := Policy(list.FunctionConfig)
+	if err != nil {
+		return err
+	}
+
+	indexes := nextIndexes(list.Items)
+	var items []map[string]interface{}
+	for _, item := range(list.Items) {
+		hardened, results, err := hardenItem(item, pol, indexes)
+		if err != nil {
+			return err
+		}
+		items = append(items, hardened...)
+		list.Results = append(list.Results, results...)
+	}
+	list.Items = items
+	return nil
+}
+
+// Policy returns the policy of the functionConfig: either the `policy` key of a ConfigMap, with the name of a
+// built-in policy or a policy document, or the spec of any other kind, decoded as a policy document
+func Policy(functionConfig map[string]interface{}) (policy.Policy, error) {
+	if functionConfig == nil {
+		return policy.Policy{}, errors.New("The functionConfig with the policy is missing")
+	}
+
+	u := unstructured.Unstructured{Object: functionConfig}
+	if u.GetKind() == "ConfigMap" {
+		value, ok, _ := unstructured.NestedString(functionConfig, "data", "policy")
+		if !ok {
+			return policy.Policy{}, errors.New("The functionConfig ConfigMap has no policy key")
+		}
+		if pol, ok := policy.Builtin(value); ok {
+			return pol, nil
+		}
+		return parsePolicy([]byte(value))
+	}
+
+	spec, ok, _ := unstructured.NestedMap(functionConfig, "spec")
+	if !ok {
+		return policy.Policy{}, fmt.Errorf("The functionConfig %v has no spec", u.GetKind())
+	}
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return policy.Policy{}, err
+	}
+	return parsePolicy(data)
+}
+
+func parsePolicy(data []byte) (policy.Policy, error) {
+	pol, err := policy.Parse(data)
+	if err != nil {
+		return pol, fmt.Errorf("Invalid policy in functionConfig:\n%w", err)
+	}
+	return pol, nil
+}
+
+// filePath returns the file of an item, or "" if it is not set
+func filePath(annotations map[string]string) (string) {
+	if path := annotations[pathAnnotation]; path != "" {
+		return path
+	}
+	return annotations[internalPathAnnotation]
+}
+
+// nextIndexes returns the index after the last item of each file
+func nextIndexes(items []map[string]interface{}) (map[string]int) {
+	result := map[string]int{}
+	for _, item := range(items) {
+		annotations := (&unstructured.Unstructured{Object: item}).GetAnnotations()
+		path := filePath(annotations)
+		if path == "" {
+			continue
+		}
+		// items without an index come after the ones before them
+		next := result[path] + 1
+		for _, key := range([]string{indexAnnotation, internalIndexAnnotation}) {
+			if index, err := strconv.Atoi(annotations[key]); err == nil && index + 1 > next {
+				next = index + 1
+			}
+		}
+		result[path] = next
+	}
+	return result
+}
+
+// hardenItem returns the hardened item, followed by its generated service account, and the results of its findings.
+// The service account is written at the end of the file of the item, whose next index is incremented
+func hardenItem(item map[string]interface{}, pol policy.Policy, indexes map[string]int) ([]map[string]interface{}, []Result, error) {
+	unchanged := []map[string]interface{}{item}
+	u := unstructured.Unstructured{Object: item}
+	gvk := u.GroupVersionKind()
+	if !generator.Supports(&gvk, pol) {
+		return unchanged, nil, nil
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, nil, err
+	}
+	documents, err := utils.DecodeDocument(data)
+	if err != nil || len(documents) != 1 {
+		return unchanged, nil, nil
+	}
+	document := documents[0]
+
+	hardened, findings, err := generator.GenerateHardenedObject(document.Object, document.GVK, pol)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the findings reported again on the hardened object are the ones hardening doesn't fix, e.g. of custom
+	// rules without a patch
+	_, remaining, err := generator.GenerateHardenedObject(hardened, document.GVK, pol)
+	if err != nil {
+		return nil, nil, err
+	}
+	unfixed := map[string]int{}
+	for _, f := range(remaining) {
+		unfixed[f.Rule + "/" + f.Container]++
+	}
+	var results []Result
+	for _, f := range(findings) {
+		key := f.Rule + "/" + f.Container
+		fixed := unfixed[key] == 0
+		if !fixed {
+			unfixed[key]--
+		}
+		results = append(results, newResult(u, f.Message, resultSeverity(f.Severity, fixed), f.Rule, f.Container))
+	}
+
+	var items []map[string]interface{}
+	if equality.Semantic.DeepEqual(document.Object, hardened) {
+		items = unchanged
+	} else {
+		converted, err := utils.ToUnstructured(hardened, item)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = []map[string]interface{}{converted}
+	}
+
+	if serviceAccount := generator.GenerateServiceAccount(document.Object, document.GVK, pol); serviceAccount != nil {
+		converted, err := utils.ToUnstructured(serviceAccount, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		// the service account is written to the file of the workload
+		sa := unstructured.Unstructured{Object: converted}
+		annotations := map[string]string{}
+		if path := filePath(u.GetAnnotations()); path != "" {
+			index := strconv.Itoa(indexes[path])
+			indexes[path]++
+			for _, key := range([][2]string{{pathAnnotation, indexAnnotation}, {internalPathAnnotation, internalIndexAnnotation}}) {
+				if value, ok := u.GetAnnotations()[key[0]]; ok {
+					annotations[key[0]], annotations[key[1]] = value, index
+				}
+			}
+			sa.SetAnnotations(annotations)
+		}
+		items = append(items, converted)
+		metadata, _ := meta.Accessor(serviceAccount)
+		results = append(results, newResult(u, fmt.Sprintf("Generated ServiceAccount %v.", metadata.GetName()), "info", policy.RuleDefaultServiceAccount, ""))
+	}
+
+	return items, results, nil
+}
+
+// resultSeverity returns the severity of the result of a finding. Only the findings that hardening didn't fix
+// are errors, so that a successful run doesn't fail the pipeline: critical and high findings are errors if
+// they are not fixed and warnings otherwise, medium ones are warnings if they are not fixed, and the others
+// are informational
+func resultSeverity(severity string, fixed bool) (string) {
+	switch {
+		case (severity == "critical" || severity == "high") && !fixed:
+			return "error"
+		case severity == "critical" || severity == "high" || severity == "medium" && !fixed:
+			return "warning"
+	}
+	return "info"
+}
+
+func newResult(u unstructured.Unstructured, message string, severity string, rule string, container string) (Result) {
+	result := Result{
+		Message: message,
+		Severity: severity,
+		ResourceRef: &ResourceRef{APIVersion: u.GetAPIVersion(), Kind: u.GetKind(), Name: u.GetName(), Namespace: u.GetNamespace()},
+		Tags: map[string]string{"rule": rule},
+	}
+	if container != "" {
+		result.Tags["container"] = container
+	}
+	if path := filePath(u.GetAnnotations()); path != "" {
+		result.File = &File{Path: path}
+	}
+	return result
+}
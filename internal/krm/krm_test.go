@@ -0,0 +1,205 @@
+package krm
+
+import (
+	"strings"
+	"testing"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const resourceList = `
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  apiVersion: manifest-hardening/v1
+  kind: HardeningPolicy
+  metadata:
+    name: policy
+  spec:
+    Extends: restricted
+    DefaultServiceAccount: false
+    CustomRules:
+    - ID: registry
+      Scope: Container
+      Expression: container.image.startsWith('registry.corp/')
+      Severity: high
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: settings
+  data:
+    key: value
+- apiVersion: example.com/v1
+  kind: Widget
+  metadata:
+    name: widget
+  spec:
+    hostNetwork: true
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: web
+    annotations:
+      config.kubernetes.io/path: web/pod.yaml
+  spec:
+    hostNetwork: true
+    containers:
+    - name: web
+      image: nginx
+`
+
+func TestRun(t *testing.T) {
+	output, err := Run([]byte(resourceList))
+	if err != nil {
+		t.Fatalf("TestRun returned %v", err)
+	}
+
+	var list ResourceList
+	if err := yaml.Unmarshal(output, &list); err != nil {
+		t.Fatalf("TestRun returned an invalid ResourceList: %v", err)
+	}
+	if len(list.Items) != 4 {
+		t.Fatalf("TestRun returned %v items", len(list.Items))
+	}
+
+	// custom resources that are not listed in the Workloads of the policy are kept unchanged
+	if hostNetwork, _, _ := unstructured.NestedBool(list.Items[1], "spec", "hostNetwork"); !hostNetwork {
+		t.Fatalf("TestRun modified a custom resource: %v", list.Items[1])
+	}
+
+	pod := unstructured.Unstructured{Object: list.Items[2]}
+	if hostNetwork, _, _ := unstructured.NestedBool(pod.Object, "spec", "hostNetwork"); hostNetwork {
+		t.Fatalf("TestRun did not harden the Pod")
+	}
+	if _, found, _ := unstructured.NestedFieldNoCopy(pod.Object, "metadata", "creationTimestamp"); found {
+		t.Fatalf("TestRun added an empty creationTimestamp")
+	}
+
+	sa := unstructured.Unstructured{Object: list.Items[3]}
+	if sa.GetKind() != "ServiceAccount" || sa.GetAnnotations()[pathAnnotation] != "web/pod.yaml" {
+		t.Fatalf("TestRun returned %v %v", sa.GetKind(), sa.GetAnnotations())
+	}
+
+	// host-network and registry findings are high, but only the registry rule has no patch, so hardening
+	// doesn't fix it
+	severities := map[string]string{}
+	for _, r := range(list.Results) {
+		if r.File != nil && r.File.Path == "web/pod.yaml" && r.ResourceRef.Name == "web" {
+			severities[r.Tags["rule"]] = r.Severity
+		}
+	}
+	if severities["host-network"] != "warning" || severities["registry"] != "error" || severities["seccomp"] != "info" {
+		t.Fatalf("TestRun returned results %v", list.Results)
+	}
+}
+
+func TestResultSeverity(t *testing.T) {
+	tests := []struct {
+		severity string
+		fixed bool
+		expected string
+	}{
+		{severity: "critical", fixed: false, expected: "error"},
+		{severity: "critical", fixed: true, expected: "warning"},
+		{severity: "high", fixed: false, expected: "error"},
+		{severity: "high", fixed: true, expected: "warning"},
+		{severity: "medium", fixed: false, expected: "warning"},
+		{severity: "medium", fixed: true, expected: "info"},
+		{severity: "low", fixed: false, expected: "info"},
+		{severity: "", fixed: false, expected: "info"},
+	}
+	for _, test := range(tests) {
+		if severity := resultSeverity(test.severity, test.fixed); severity != test.expected {
+			t.Fatalf("TestResultSeverity returned %v for %v findings fixed %v", severity, test.severity, test.fixed)
+		}
+	}
+}
+
+func TestRunInvalidPolicy(t *testing.T) {
+	input := strings.Replace(resourceList, "DefaultServiceAccount: false", "DefaultServiceAcount: false", 1)
+
+	output, err := Run([]byte(input))
+	if err == nil {
+		t.Fatalf("TestRunInvalidPolicy accepted an invalid policy")
+	}
+
+	var list ResourceList
+	if err := yaml.Unmarshal(output, &list); err != nil || len(list.Results) != 1 || list.Results[0].Severity != "error" {
+		t.Fatalf("TestRunInvalidPolicy returned %v", list.Results)
+	}
+}
+
+func TestPolicyConfigMap(t *testing.T) {
+	functionConfig := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind": "ConfigMap",
+		"data": map[string]interface{}{"policy": "baseline"},
+	}
+	pol, err := Policy(functionConfig)
+	if err != nil || pol.Privileged != false || pol.RunAsNonRoot != false {
+		t.Fatalf("TestPolicyConfigMap returned %v", err)
+	}
+}
+
+func TestRunWorkloads(t *testing.T) {
+	input := strings.Replace(resourceList, "    DefaultServiceAccount: false\n", `    Workloads:
+      - APIVersion: example.com/v1
+        Kind: Widget
+        PodSpecs: [spec]
+`, 1)
+
+	output, err := Run([]byte(input))
+	if err != nil {
+		t.Fatalf("TestRunWorkloads returned %v", err)
+	}
+	var list ResourceList
+	if err := yaml.Unmarshal(output, &list); err != nil {
+		t.Fatal(err)
+	}
+	if hostNetwork, _, _ := unstructured.NestedBool(list.Items[1], "spec", "hostNetwork"); hostNetwork {
+		t.Fatalf("TestRunWorkloads did not harden the custom resource: %v", list.Items[1])
+	}
+}
+
+func TestRunServiceAccountIndex(t *testing.T) {
+	input := strings.Replace(resourceList, "      config.kubernetes.io/path: web/pod.yaml\n", `      config.kubernetes.io/path: web/pod.yaml
+      config.kubernetes.io/index: '0'
+      internal.config.kubernetes.io/path: web/pod.yaml
+      internal.config.kubernetes.io/index: '0'
+`, 1) + `- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: api
+    annotations:
+      config.kubernetes.io/path: web/pod.yaml
+      config.kubernetes.io/index: '1'
+  spec:
+    containers:
+    - name: api
+      image: nginx
+`
+	output, err := Run([]byte(input))
+	if err != nil {
+		t.Fatalf("TestRunServiceAccountIndex returned %v", err)
+	}
+	var list ResourceList
+	if err := yaml.Unmarshal(output, &list); err != nil {
+		t.Fatal(err)
+	}
+
+	// the service accounts are appended to the file of their workload, after its last item
+	indexes := map[string]string{}
+	for _, item := range(list.Items) {
+		u := unstructured.Unstructured{Object: item}
+		if u.GetKind() == "ServiceAccount" {
+			indexes[u.GetName()] = u.GetAnnotations()[indexAnnotation]
+			if u.GetName() == "web" && u.GetAnnotations()[internalIndexAnnotation] != "2" {
+				t.Fatalf("TestRunServiceAccountIndex returned the annotations %v", u.GetAnnotations())
+			}
+		}
+	}
+	if indexes["web"] != "2" || indexes["api"] != "3" {
+		t.Fatalf("TestRunServiceAccountIndex returned the indexes %v", indexes)
+	}
+}
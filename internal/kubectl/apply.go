@@ -0,0 +1,374 @@
+package kubectl
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// serverFields are the fields managed by the server, which are never applied
+var serverFields = [][]string{
+	{"metadata", "managedFields"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "generation"},
+	{"metadata", "uid"},
+	{"spec", "template", "metadata", "creationTimestamp"},
+	{"status"},
+}
+
+// content returns the unstructured content of the object, without the fields managed by the server
+func content(obj runtime.Object) (map[string]interface{}, error) {
+	if obj.GetObjectKind().GroupVersionKind().Empty() {
+		return nil, fmt.Errorf("Error applying the hardened object: missing apiVersion and kind")
+	}
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj.DeepCopyObject())
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range(serverFields) {
+		unstructured.RemoveNestedField(u, path...)
+	}
+	return u, nil
+}
+
+// applyConfiguration returns the server-side apply configuration of the hardened object: the fields that
+// hardening changed, the fields the field manager already owns, so that they are not removed by the apply,
+// and the identity of the object. The resourceVersion is kept, so the object is not applied if it was
+// modified after it was read. Objects without an original, e.g. the generated service account, are
+// applied as a whole. The fields removed by hardening are returned separately, see removals
+func applyConfiguration(original runtime.Object, hardened runtime.Object, fieldManager string) ([]byte, map[string]interface{}, error) {
+	hardenedContent, err := content(hardened)
+	if err != nil {
+		return nil, nil, err
+	}
+	if original == nil {
+		data, err := json.Marshal(hardenedContent)
+		return data, nil, err
+	}
+	originalContent, err := content(original)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, isUnstructured := hardened.(*unstructured.Unstructured)
+	removed := removals(originalContent, hardenedContent, !isUnstructured)
+
+	config := changes(originalContent, hardenedContent)
+	managed, err := ownedFields(original, fieldManager)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owned, ok := ownedContent(managed, hardenedContent).(map[string]interface{}); ok {
+		config = mergeContent(config, owned).(map[string]interface{})
+	}
+
+	u := &unstructured.Unstructured{Object: hardenedContent}
+	config["apiVersion"], config["kind"] = u.GetAPIVersion(), u.GetKind()
+	metadata, _ := config["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["name"] = u.GetName()
+	if u.GetNamespace() != "" {
+		metadata["namespace"] = u.GetNamespace()
+	}
+	if u.GetResourceVersion() != "" {
+		metadata["resourceVersion"] = u.GetResourceVersion()
+	}
+	config["metadata"] = metadata
+
+	data, err := json.Marshal(config)
+	return data, removed, err
+}
+
+// changes returns the fields of the hardened content that differ from the original one. Lists of named
+// items (e.g. containers and volumes) are associative in server-side apply, so only their changed items are
+// returned, with their name. Other lists are atomic and are returned as a whole
+func changes(original map[string]interface{}, hardened map[string]interface{}) (map[string]interface{}) {
+	result := map[string]interface{}{}
+	for k, h := range(hardened) {
+		o, ok := original[k]
+		if ok && reflect.DeepEqual(o, h) {
+			continue
+		}
+		switch hv := h.(type) {
+			case map[string]interface{}:
+				if ov, isMap := o.(map[string]interface{}); isMap {
+					if c := changes(ov, hv); len(c) > 0 {
+						result[k] = c
+					}
+					continue
+				}
+			case []interface{}:
+				if ov, isList := o.([]interface{}); isList && namedList(ov) && namedList(hv) {
+					var items []interface{}
+					for _, item := range(hv) {
+						name := item.(map[string]interface{})["name"]
+						previous := namedItem(ov, name)
+						if previous == nil {
+							items = append(items, item)
+						} else if c := changes(previous, item.(map[string]interface{})); len(c) > 0 {
+							c["name"] = name
+							items = append(items, c)
+						}
+					}
+					if len(items) > 0 {
+						result[k] = items
+					}
+					continue
+				}
+		}
+		result[k] = h
+	}
+	// zero values are omitted from typed objects, e.g. hostNetwork set to false
+	for k, o := range(original) {
+		if _, ok := hardened[k]; !ok && zeroValue(o) != nil {
+			result[k] = zeroValue(o)
+		}
+	}
+	return result
+}
+
+// zeroValue returns the zero value of a scalar, or nil for lists and objects
+func zeroValue(value interface{}) (interface{}) {
+	switch value.(type) {
+		case bool:
+			return false
+		case string:
+			return ""
+		case int64:
+			return int64(0)
+		case float64:
+			return float64(0)
+	}
+	return nil
+}
+
+// removals returns the patch that removes the lists and objects of the original content that are not in the
+// hardened one, or nil if there are none. Removed scalars are applied with their zero value, see changes. Server-side apply only removes the fields owned by the field manager, so
+// they are removed with a strategic merge patch, where removed items of named lists are deleted with
+// $patch: delete, or with a JSON merge patch for custom resources, where these lists are replaced
+func removals(original map[string]interface{}, hardened map[string]interface{}, strategic bool) (map[string]interface{}) {
+	result := map[string]interface{}{}
+	for k, o := range(original) {
+		h, ok := hardened[k]
+		if !ok && zeroValue(o) == nil {
+			result[k] = nil
+			continue
+		}
+		switch ov := o.(type) {
+			case map[string]interface{}:
+				if hv, isMap := h.(map[string]interface{}); isMap {
+					if r := removals(ov, hv, strategic); len(r) > 0 {
+						result[k] = r
+					}
+				}
+			case []interface{}:
+				hv, isList := h.([]interface{})
+				if !isList || !namedList(ov) || !namedList(hv) {
+					continue
+				}
+				var items []interface{}
+				for _, item := range(ov) {
+					name := item.(map[string]interface{})["name"]
+					current := namedItem(hv, name)
+					if current == nil {
+						items = append(items, map[string]interface{}{"name": name, "$patch": "delete"})
+					} else if r := removals(item.(map[string]interface{}), current, strategic); len(r) > 0 {
+						r["name"] = name
+						items = append(items, r)
+					}
+				}
+				if len(items) > 0 && strategic {
+					result[k] = items
+				} else if len(items) > 0 {
+					result[k] = hv
+				}
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// namedList returns true if every item of the list is an object with a name
+func namedList(list []interface{}) (bool) {
+	for _, item := range(list) {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if name, ok := m["name"].(string); !ok || name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func namedItem(list []interface{}, name interface{}) (map[string]interface{}) {
+	for _, item := range(list) {
+		if m := item.(map[string]interface{}); m["name"] == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// mergeContent adds the fields of b that are not in a. Lists of named items are merged by name, other
+// lists and values are taken from a
+func mergeContent(a interface{}, b interface{}) (interface{}) {
+	if am, ok := a.(map[string]interface{}); ok {
+		bm, ok := b.(map[string]interface{})
+		if !ok {
+			return a
+		}
+		for k, v := range(bm) {
+			if av, ok := am[k]; ok {
+				am[k] = mergeContent(av, v)
+			} else {
+				am[k] = v
+			}
+		}
+		return am
+	}
+	al, ok := a.([]interface{})
+	if !ok {
+		return a
+	}
+	bl, ok := b.([]interface{})
+	if !ok || !namedList(al) || !namedList(bl) {
+		return a
+	}
+	for _, item := range(bl) {
+		name := item.(map[string]interface{})["name"]
+		if existing := namedItem(al, name); existing != nil {
+			mergeContent(existing, item)
+		} else {
+			al = append(al, item)
+		}
+	}
+	return al
+}
+
+// ownedFields returns the fields applied by the field manager, in the format of the managedFields
+func ownedFields(obj runtime.Object, fieldManager string) (map[string]interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for _, entry := range(accessor.GetManagedFields()) {
+		if entry.Manager != fieldManager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.Subresource != "" || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			return nil, fmt.Errorf("Error reading the managed fields of %v:\n%s", accessor.GetName(), err)
+		}
+		mergeContent(result, fields)
+	}
+	return result, nil
+}
+
+// ownedContent returns the part of the content described by a set of managed fields. Fields are prefixed
+// with f:, items of associative lists with k: and their keys, and items of sets with v: and their value
+func ownedContent(fields map[string]interface{}, content interface{}) (interface{}) {
+	switch c := content.(type) {
+		case map[string]interface{}:
+			result := map[string]interface{}{}
+			for key, sub := range(fields) {
+				if !strings.HasPrefix(key, "f:") {
+					continue
+				}
+				name := strings.TrimPrefix(key, "f:")
+				value, ok := c[name]
+				if !ok {
+					continue
+				}
+				subFields, _ := sub.(map[string]interface{})
+				if _, isMap := value.(map[string]interface{}); len(subFields) == 0 || !isMap && leaf(subFields) {
+					result[name] = value
+				} else {
+					result[name] = ownedContent(subFields, value)
+				}
+			}
+			return result
+		case []interface{}:
+			var result []interface{}
+			for key, sub := range(fields) {
+				switch {
+					case strings.HasPrefix(key, "k:"):
+						var itemKey map[string]interface{}
+						if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "k:")), &itemKey); err != nil {
+							continue
+						}
+						item := keyedItem(c, itemKey)
+						if item == nil {
+							continue
+						}
+						subFields, _ := sub.(map[string]interface{})
+						owned, _ := ownedContent(subFields, item).(map[string]interface{})
+						for k := range(itemKey) {
+							owned[k] = item[k]
+						}
+						result = append(result, owned)
+					case strings.HasPrefix(key, "v:"):
+						var value interface{}
+						if err := json.Unmarshal([]byte(strings.TrimPrefix(key, "v:")), &value); err != nil {
+							continue
+						}
+						for _, item := range(c) {
+							if jsonEqual(item, value) {
+								result = append(result, item)
+							}
+						}
+				}
+			}
+			return result
+	}
+	return content
+}
+
+// leaf returns true if the managed fields only own the presence of a value, not its fields
+func leaf(fields map[string]interface{}) (bool) {
+	for key := range(fields) {
+		if key != "." {
+			return false
+		}
+	}
+	return true
+}
+
+// keyedItem returns the item of an associative list with the given key fields
+func keyedItem(list []interface{}, key map[string]interface{}) (map[string]interface{}) {
+	for _, item := range(list) {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		matches := true
+		for k, v := range(key) {
+			if !jsonEqual(m[k], v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return m
+		}
+	}
+	return nil
+}
+
+// jsonEqual compares values of the unstructured content and of decoded JSON, whose numbers are float64
+func jsonEqual(a interface{}, b interface{}) (bool) {
+	aData, aErr := json.Marshal(a)
+	bData, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && string(aData) == string(bData)
+}
@@ -0,0 +1,181 @@
+package kubectl
+
+import (
+	"bytes"
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"encoding/json"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+// DefaultFieldManager is the field manager of the server-side apply requests
+const DefaultFieldManager = "manifest-hardening"
+
+// BuilderFunc returns a new builder for the cluster, e.g. resource.NewBuilder(configFlags)
+type BuilderFunc func() (*resource.Builder)
+
+// Object is a live object and the result of hardening it
+type Object struct {
+	Info *resource.Info
+	Original runtime.Object
+	Hardened runtime.Object
+	ServiceAccount runtime.Object // generated service account, nil if the policy allows the default one
+	Findings []generator.Finding
+	Policy string // policy used and where it comes from
+}
+
+// Changed returns true if the hardened object differs from the live one or needs a service account
+func (o Object) Changed() (bool) {
+	return o.ServiceAccount != nil || !equality.Semantic.DeepEqual(o.Original, o.Hardened)
+}
+
+// Harden fetches the resources given as kubectl arguments (`deploy/web`, `deployment web`) from the namespace
+// and hardens them with the policy chosen by the selector. The Pod Security Admission labels of the live
+// namespaces are added to the selector. Resources of unsupported kinds, including the custom resources
+// that are not listed in the Workloads of the policy, are returned unchanged
+func Harden(newBuilder BuilderFunc, selector *policy.Selector, namespace string, args []string) ([]Object, error) {
+	infos, err := newBuilder().
+		Unstructured().
+		NamespaceParam(namespace).DefaultNamespace().
+		ResourceTypeOrNameArgs(false, args...).
+		Flatten().
+		Do().Infos()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []Object
+	registered := map[string]bool{}
+	for _, info := range(infos) {
+		data, err := json.Marshal(info.Object)
+		if err != nil {
+			return nil, err
+		}
+		documents, err := utils.DecodeDocument(data)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding %v %v:\n%s", info.Mapping.GroupVersionKind.Kind, info.Name, err)
+		}
+		document := documents[0]
+		gvk := *document.GVK
+
+		if info.Namespace != "" && !registered[info.Namespace] {
+			registered[info.Namespace] = true
+			if err := registerNamespace(newBuilder, selector, info.Namespace); err != nil {
+				return nil, err
+			}
+		}
+
+		o := Object{Info: info, Original: document.Object, Hardened: document.Object}
+		pol, source, err := selector.Select(info.Namespace)
+		if !generator.SupportedKind(document.GVK) && (err != nil || !generator.Supports(document.GVK, pol)) {
+			objects = append(objects, o)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error selecting the policy of %v %v:\n%s", gvk.Kind, info.Name, err)
+		}
+		if o.Hardened, o.Findings, err = generator.GenerateHardenedObject(document.Object, document.GVK, pol); err != nil {
+			return nil, err
+		}
+		o.ServiceAccount = generator.GenerateServiceAccount(document.Object, document.GVK, pol)
+		o.Policy = source
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// registerNamespace adds the labels of a live namespace to the selector. Namespaces that can't be read
+// are skipped, the policy flags apply to them
+func registerNamespace(newBuilder BuilderFunc, selector *policy.Selector, name string) (error) {
+	infos, err := newBuilder().
+		Unstructured().
+		ResourceTypeOrNameArgs(false, "namespaces", name).
+		Do().Infos()
+	if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var ns corev1.Namespace
+	u := infos[0].Object.(*unstructured.Unstructured)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &ns); err != nil {
+		return err
+	}
+	return selector.AddNamespace(&ns)
+}
+
+// Apply applies the changes of hardening and the generated service account with server-side apply. The apply
+// configuration only has the fields changed by hardening and the fields the field manager already owns, so
+// it doesn't take over the rest of the object. Fields owned by other managers (e.g. the securityContext set
+// by a Helm chart) are only taken over with force. Fields removed by hardening, e.g. hostPath volumes, are
+// removed with a patch after the apply
+func Apply(newBuilder BuilderFunc, o Object, fieldManager string, force bool) ([]*resource.Info, error) {
+	var stream bytes.Buffer
+	var removed []map[string]interface{}
+	for _, c := range([][]runtime.Object{{nil, o.ServiceAccount}, {o.Original, o.Hardened}}) {
+		if c[1] == nil {
+			continue
+		}
+		data, r, err := applyConfiguration(c[0], c[1], fieldManager)
+		if err != nil {
+			return nil, err
+		}
+		stream.Write(data)
+		stream.WriteString("\n")
+		removed = append(removed, r)
+	}
+
+	infos, err := newBuilder().
+		Unstructured().
+		NamespaceParam(o.Info.Namespace).DefaultNamespace().
+		Stream(&stream, "hardened").
+		Flatten().
+		Do().Infos()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, info := range(infos) {
+		data, err := json.Marshal(info.Object)
+		if err != nil {
+			return nil, err
+		}
+		helper := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(fieldManager)
+		applied, err := helper.Patch(info.Namespace, info.Name, types.ApplyPatchType, data, &metav1.PatchOptions{Force: &force})
+		if err != nil {
+			return nil, fmt.Errorf("Error applying %v %v:\n%s", info.Mapping.GroupVersionKind.Kind, info.Name, err)
+		}
+		info.Refresh(applied, true)
+
+		if i >= len(removed) || removed[i] == nil {
+			continue
+		}
+		// the resourceVersion of the applied object makes sure that nothing changed in between
+		patchType := types.StrategicMergePatchType
+		if _, ok := o.Hardened.(*unstructured.Unstructured); ok {
+			patchType = types.MergePatchType
+		}
+		removed[i]["metadata"] = map[string]interface{}{"resourceVersion": info.ResourceVersion}
+		data, err = json.Marshal(removed[i])
+		if err != nil {
+			return nil, err
+		}
+		patched, err := helper.Patch(info.Namespace, info.Name, patchType, data, &metav1.PatchOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("Error removing the fields removed by hardening from %v %v:\n%s", info.Mapping.GroupVersionKind.Kind, info.Name, err)
+		}
+		info.Refresh(patched, true)
+	}
+	return infos, nil
+}
@@ -0,0 +1,237 @@
+package kubectl
+
+import (
+	"bytes"
+	"edurra/manifest-hardening/internal/policy"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest/fake"
+	"k8s.io/client-go/restmapper"
+)
+
+const liveDeployment = `{
+	"apiVersion": "apps/v1",
+	"kind": "Deployment",
+	"metadata": {"name": "web", "namespace": "prod", "resourceVersion": "42", "managedFields": [{"manager": "helm", "operation": "Update"}]},
+	"spec": {
+		"selector": {"matchLabels": {"app": "web"}},
+		"template": {
+			"metadata": {"labels": {"app": "web"}},
+			"spec": {"hostNetwork": true, "containers": [{"name": "web", "image": "nginx"}]}
+		}
+	}
+}`
+
+const liveNamespace = `{
+	"apiVersion": "v1",
+	"kind": "Namespace",
+	"metadata": {"name": "prod", "labels": {"pod-security.kubernetes.io/enforce": "baseline"}}
+}`
+
+// fakeCluster serves the live objects and records the apply requests
+type fakeCluster struct {
+	objects map[string]string // path to JSON object
+	applied map[string]string // path to body of the apply request
+}
+
+func (c *fakeCluster) do(req *http.Request) (*http.Response, error) {
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	switch req.Method {
+		case http.MethodGet:
+			if body, ok := c.objects[req.URL.Path]; ok {
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(body))}, nil
+			}
+			status := `{"kind": "Status", "apiVersion": "v1", "status": "Failure", "reason": "NotFound", "code": 404}`
+			return &http.Response{StatusCode: http.StatusNotFound, Header: header, Body: io.NopCloser(strings.NewReader(status))}, nil
+		case http.MethodPatch:
+			body, _ := io.ReadAll(req.Body)
+			c.applied[req.URL.Path + "?" + req.URL.RawQuery + " " + req.Header.Get("Content-Type")] = string(body)
+			// the removal patches are partial, the live object is returned instead
+			if live, ok := c.objects[req.URL.Path]; ok && req.Header.Get("Content-Type") != "application/apply-patch+yaml" {
+				body = []byte(live)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewReader(body))}, nil
+	}
+	return &http.Response{StatusCode: http.StatusMethodNotAllowed, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func (c *fakeCluster) builder() (*resource.Builder) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.AddSpecific(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployment"}, meta.RESTScopeNamespace)
+	mapper.AddSpecific(schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"},
+		schema.GroupVersionResource{Version: "v1", Resource: "serviceaccounts"},
+		schema.GroupVersionResource{Version: "v1", Resource: "serviceaccount"}, meta.RESTScopeNamespace)
+	mapper.AddSpecific(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"},
+		schema.GroupVersionResource{Version: "v1", Resource: "namespaces"},
+		schema.GroupVersionResource{Version: "v1", Resource: "namespace"}, meta.RESTScopeRoot)
+
+	clientFn := func(version schema.GroupVersion) (resource.RESTClient, error) {
+		return &fake.RESTClient{
+			GroupVersion: version,
+			NegotiatedSerializer: resource.UnstructuredPlusDefaultContentConfig().NegotiatedSerializer,
+			Client: fake.CreateHTTPClient(c.do),
+		}, nil
+	}
+	return resource.NewFakeBuilder(clientFn, func() (meta.RESTMapper, error) {
+		return mapper, nil
+	}, func() (restmapper.CategoryExpander, error) {
+		return resource.FakeCategoryExpander, nil
+	})
+}
+
+func newFakeCluster(namespaceLabels bool) (*fakeCluster) {
+	c := &fakeCluster{
+		objects: map[string]string{"/namespaces/prod/deployments/web": liveDeployment},
+		applied: map[string]string{},
+	}
+	if namespaceLabels {
+		c.objects["/namespaces/prod"] = liveNamespace
+	}
+	return c
+}
+
+func TestHarden(t *testing.T) {
+	c := newFakeCluster(false)
+	selector, err := policy.NewSelector("restricted", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := Harden(c.builder, selector, "prod", []string{"deployment/web"})
+	if err != nil {
+		t.Fatalf("TestHarden returned %v", err)
+	}
+	if len(objects) != 1 || !objects[0].Changed() || len(objects[0].Findings) == 0 {
+		t.Fatalf("TestHarden returned %v", objects)
+	}
+	if objects[0].Hardened.(*appsv1.Deployment).Spec.Template.Spec.HostNetwork {
+		t.Fatalf("TestHarden did not harden the Deployment")
+	}
+	if !strings.Contains(objects[0].Policy, "policy flag") {
+		t.Fatalf("TestHarden used the policy %v", objects[0].Policy)
+	}
+}
+
+func TestHardenNamespaceLabels(t *testing.T) {
+	c := newFakeCluster(true)
+	selector, err := policy.NewSelector("restricted", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := Harden(c.builder, selector, "prod", []string{"deployment", "web"})
+	if err != nil {
+		t.Fatalf("TestHardenNamespaceLabels returned %v", err)
+	}
+	if len(objects) != 1 || !strings.HasPrefix(objects[0].Policy, "baseline (namespace label") {
+		t.Fatalf("TestHardenNamespaceLabels used the policy %v", objects[0].Policy)
+	}
+	if objects[0].ServiceAccount != nil {
+		t.Fatalf("TestHardenNamespaceLabels generated a service account with the baseline policy")
+	}
+}
+
+func TestApply(t *testing.T) {
+	c := newFakeCluster(false)
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(policyFile, []byte("Extends: restricted\nDefaultServiceAccount: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	selector, err := policy.NewSelector(policyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := Harden(c.builder, selector, "prod", []string{"deployment/web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := Apply(c.builder, objects[0], DefaultFieldManager, false)
+	if err != nil {
+		t.Fatalf("TestApply returned %v", err)
+	}
+	if len(infos) != 2 || len(c.applied) != 2 {
+		t.Fatalf("TestApply applied %v", c.applied)
+	}
+
+	deployment, ok := c.applied["/namespaces/prod/deployments/web?fieldManager=manifest-hardening&force=false application/apply-patch+yaml"]
+	if !ok {
+		t.Fatalf("TestApply sent %v", c.applied)
+	}
+	if strings.Contains(deployment, "managedFields") || !strings.Contains(deployment, `"resourceVersion":"42"`) || !strings.Contains(deployment, "RuntimeDefault") {
+		t.Fatalf("TestApply applied %v", deployment)
+	}
+	// only the fields changed by hardening are applied, so the field manager doesn't own the rest
+	if strings.Contains(deployment, "nginx") || strings.Contains(deployment, "selector") || !strings.Contains(deployment, `"hostNetwork":false`) {
+		t.Fatalf("TestApply applied %v", deployment)
+	}
+	if _, ok := c.applied["/namespaces/prod/serviceaccounts/web?fieldManager=manifest-hardening&force=false application/apply-patch+yaml"]; !ok {
+		t.Fatalf("TestApply did not apply the service account: %v", c.applied)
+	}
+}
+
+func TestApplyConfiguration(t *testing.T) {
+	runAsNonRoot := true
+	original := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "prod", ResourceVersion: "7", ManagedFields: []metav1.ManagedFieldsEntry{{
+			Manager: DefaultFieldManager,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"web\"}":{".":{},"f:name":{},"f:securityContext":{"f:runAsNonRoot":{}}}}}}}}`)},
+		}}},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "web", Image: "nginx", SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &runAsNonRoot}}},
+			Volumes: []corev1.Volume{
+				{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/"}}},
+				{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		}}},
+	}
+	hardened := original.DeepCopy()
+	hardened.Spec.Template.Spec.Volumes = hardened.Spec.Template.Spec.Volumes[1:]
+	hardened.Spec.Template.Spec.Containers[0].SecurityContext.AllowPrivilegeEscalation = new(bool)
+
+	data, removed, err := applyConfiguration(original, hardened, DefaultFieldManager)
+	if err != nil {
+		t.Fatalf("TestApplyConfiguration returned %v", err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{"name": "web", "namespace": "prod", "resourceVersion": "7"},
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{
+				"name": "web",
+				// runAsNonRoot is owned by the field manager, so it must be applied again to be kept
+				"securityContext": map[string]interface{}{"allowPrivilegeEscalation": false, "runAsNonRoot": true},
+			}},
+		}}},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Fatalf("TestApplyConfiguration returned %v", config)
+	}
+
+	volumes, _, _ := unstructured.NestedSlice(removed, "spec", "template", "spec", "volumes")
+	if !reflect.DeepEqual(volumes, []interface{}{map[string]interface{}{"name": "host", "$patch": "delete"}}) {
+		t.Fatalf("TestApplyConfiguration removed %v", removed)
+	}
+}
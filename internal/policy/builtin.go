@@ -0,0 +1,61 @@
+package policy
+
+// Builtin returns the PSS policy with the given name {privileged, baseline, restricted}
+func Builtin(name string) (Policy, bool) {
+	policies := map[string]Policy{
+		"privileged": unrestricted(),
+		"baseline": Policy{
+			HostPID: false,
+			HostNetwork: false,
+			HostIPC: false,
+			Privileged: false,
+			HostProcess: false,
+			CapabilitiesAdd: []string{"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT"},
+			ProcMount: "Default",
+			Seccomp: []string{"RuntimeDefault", "Localhost", "Undefined"},
+			DisallowedVolumes: []string{"HostPath"},
+			AllowedVolumes: []string{"*"},
+			AllowPrivilegeEscalation: true,
+			RunAsNonRoot: false,
+			RunAsUser: false,
+			AutomountServiceAccountToken: true,
+			DefaultServiceAccount: true,
+		},
+		"restricted": Policy{
+			HostPID: false,
+			HostNetwork: false,
+			HostIPC: false,
+			Privileged: false,
+			HostProcess: false,
+			CapabilitiesAdd: []string{"NET_BIND_SERVICE"},
+			CapabilitiesDrop: []string{"ALL"},
+			ProcMount: "Default",
+			Seccomp: []string{"RuntimeDefault", "Localhost"},
+			DisallowedVolumes: []string{"HostPath"},
+			AllowedVolumes: []string{"ConfigMap", "CSI", "DownwardAPI", "EmptyDir", "Ephemeral", "PersistentVolumeClaim", "Projected", "Secret"},
+			AllowPrivilegeEscalation: false,
+			RunAsNonRoot: true,
+			RunAsUser: true,
+			AutomountServiceAccountToken: true,
+			DefaultServiceAccount: true,
+		},
+	}
+	pol, ok := policies[name]
+	return pol, ok
+}
+
+// unrestricted returns the privileged policy, which allows everything so that hardening doesn't change anything.
+// Unlike Default, it also allows every seccomp profile
+func unrestricted() (Policy) {
+	pol := Default()
+	pol.Seccomp = append([]string{}, seccompTypes...)
+	return pol
+}
+
+// Resolve returns the built-in policy with the given name, or loads the policy file at the given path
+func Resolve(nameOrPath string) (Policy, error) {
+	if pol, ok := Builtin(nameOrPath); ok {
+		return pol, nil
+	}
+	return Load(nameOrPath)
+}
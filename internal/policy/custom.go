@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+)
+
+const (
+	ScopePod = "Pod"
+	ScopeContainer = "Container"
+)
+
+// CustomRule is a rule written in CEL. The expression is evaluated with the variables podSpec and metadata
+// (of the workload) and, for container rules, container. It must return true if the object complies
+type CustomRule struct {
+	ID string `yaml:"ID"` // name used in exemptions, EnabledRules and DisabledRules
+	Description string `yaml:"Description"`
+	Scope string `yaml:"Scope"` // Pod (default) or Container. Container rules are evaluated for every container and init container
+	Expression string `yaml:"Expression"` // CEL expression, e.g. container.image.startsWith('registry.corp/')
+	Message string `yaml:"Message"` // reported when the expression is false
+	Severity string `yaml:"Severity"` // low, medium, high or critical
+	Patch []PatchOperation `yaml:"Patch"` // JSON patch applied to the pod spec or container when the expression is false
+}
+
+// PatchOperation is a JSON patch operation (RFC 6902). Paths are relative to the pod spec or the container
+type PatchOperation struct {
+	Op string `yaml:"op" json:"op"`
+	Path string `yaml:"path" json:"path"`
+	From string `yaml:"from,omitempty" json:"from,omitempty"`
+	Value interface{} `yaml:"value,omitempty" json:"value,omitempty"`
+}
+
+var severities = append([]string{""}, SeverityLevels...)
+
+var scopes = []string{"", ScopePod, ScopeContainer}
+
+var patchOperations = []string{"add", "remove", "replace", "move", "copy", "test"}
+
+// Program compiles the expression of the rule
+func (r CustomRule) Program() (cel.Program, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("podSpec", cel.DynType),
+		cel.Variable("metadata", cel.DynType),
+		cel.Variable("container", cel.DynType),
+		ext.Strings(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(r.Expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must return a bool, not %v", ast.OutputType())
+	}
+	return env.Program(ast)
+}
+
+// customRuleIDs returns the IDs of the custom rules of the policy
+func customRuleIDs(pol Policy) ([]string) {
+	var result []string
+	for _, r := range(pol.CustomRules) {
+		result = append(result, r.ID)
+	}
+	return result
+}
@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"gopkg.in/yaml.v3"
+)
+
+// document is the content of a policy file: the policy fields plus the composition keys
+type document struct {
+	Extends string `yaml:"Extends"` // built-in policy name or path (relative to the file) of the base policy
+	Merge []string `yaml:"Merge"` // list fields appended to the base policy instead of replacing it
+	Policy `yaml:",inline"`
+}
+
+// Default returns the most permissive policy. Policy files only need to set the fields they restrict
+func Default() (Policy) {
+	return Policy{
+		HostPID: true,
+		HostNetwork: true,
+		HostIPC: true,
+		Privileged: true,
+		HostProcess: true,
+		CapabilitiesAdd: []string{"ALL"},
+		CapabilitiesDrop: []string{},
+		ProcMount: "",
+		Seccomp: []string{"Undefined"},
+		AllowedVolumes: []string{"*"},
+		DisallowedVolumes: []string{},
+		AllowPrivilegeEscalation: true,
+		RunAsNonRoot: false,
+		RunAsUser: false,
+		AutomountServiceAccountToken: true,
+		AutomountServiceAccountTokenExemptions: []string{},
+		DefaultServiceAccount: true,
+		EnabledRules: []string{},
+		DisabledRules: []string{},
+		Workloads: []Workload{},
+		Severities: map[string]string{},
+	}
+}
+
+// Load reads and validates a policy file. Unknown keys and invalid values are rejected
+func Load(path string) (Policy, error) {
+	return load(path, map[string]bool{})
+}
+
+// Parse validates a policy document and decodes it on top of its base policy.
+// Relative Extends paths are resolved from the working directory
+func Parse(data []byte) (Policy, error) {
+	return parse(data, ".", map[string]bool{})
+}
+
+func load(path string, seen map[string]bool) (Policy, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	if seen[absPath] {
+		return Policy{}, fmt.Errorf("policy %v extends itself", path)
+	}
+	seen[absPath] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	pol, err := parse(data, filepath.Dir(path), seen)
+	if err != nil {
+		return Policy{}, fmt.Errorf("%v: %w", path, err)
+	}
+	return pol, nil
+}
+
+func parse(data []byte, dir string, seen map[string]bool) (Policy, error) {
+	var root yaml.Node
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return Policy{}, err
+	}
+
+	// the document is validated once the base policy is loaded, since it can reference its custom rules
+	var header struct {
+		Extends string `yaml:"Extends"`
+	}
+	root.Decode(&header)
+
+	base := Default()
+	if header.Extends != "" {
+		var err error
+		if builtin, ok := Builtin(header.Extends); ok {
+			base = builtin
+		} else if filepath.IsAbs(header.Extends) {
+			base, err = load(header.Extends, seen)
+		} else {
+			base, err = load(filepath.Join(dir, header.Extends), seen)
+		}
+		if err != nil {
+			return Policy{}, fmt.Errorf("line %d: cannot extend %v: %w", keyLine(&root, "Extends"), header.Extends, err)
+		}
+	}
+
+	if err := validateDocument(&root, customRuleIDs(base)); err != nil {
+		return Policy{}, err
+	}
+
+	doc := document{Policy: base}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return Policy{}, err
+	}
+
+	for _, field := range(doc.Merge) {
+		if keyLine(&root, field) != 0 {
+			mergeField(&doc.Policy, base, field)
+		}
+	}
+
+	return doc.Policy, nil
+}
+
+// mergeField appends the values of the base policy to a list field, skipping duplicated values
+func mergeField(pol *Policy, base Policy, field string) {
+	value := reflect.ValueOf(pol).Elem().FieldByName(field)
+	baseValue := reflect.ValueOf(base).FieldByName(field)
+
+	merged := reflect.MakeSlice(value.Type(), 0, baseValue.Len() + value.Len())
+	for _, list := range([]reflect.Value{baseValue, value}) {
+		for i := 0; i < list.Len(); i++ {
+			if !sliceContains(merged, list.Index(i)) {
+				merged = reflect.Append(merged, list.Index(i))
+			}
+		}
+	}
+	value.Set(merged)
+}
+
+func sliceContains(slice reflect.Value, item reflect.Value) (bool) {
+	for i := 0; i < slice.Len(); i++ {
+		if reflect.DeepEqual(slice.Index(i).Interface(), item.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyLine returns the line of a top level key of the document, or 0 if it is not set
+func keyLine(root *yaml.Node, key string) (int) {
+	if root.Kind == 0 || len(root.Content) == 0 {
+		return 0
+	}
+	mapping := root.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i].Line
+		}
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	pol, err := Parse([]byte("HostPID: false\nSeccomp:\n  - RuntimeDefault\nDisallowedVolumes:\n  - HostPath\n"))
+
+	if err != nil {
+		t.Fatalf("TestParse returned %v", err)
+	}
+	if pol.HostPID != false || pol.HostNetwork != true {
+		t.Fatalf("TestParse returned HostPID %v and HostNetwork %v", pol.HostPID, pol.HostNetwork)
+	}
+	if len(pol.Seccomp) != 1 || pol.Seccomp[0] != "RuntimeDefault" {
+		t.Fatalf("TestParse returned Seccomp %v", pol.Seccomp)
+	}
+	if len(pol.CapabilitiesAdd) != 1 || pol.CapabilitiesAdd[0] != "ALL" {
+		t.Fatalf("TestParse did not keep the default CapabilitiesAdd: %v", pol.CapabilitiesAdd)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	cases := map[string]string{
+		"DisAllowedVolumes:\n  - HostPath\n": `line 1: unknown key "DisAllowedVolumes" in policy (did you mean DisallowedVolumes?)`,
+		"HostPID: false\nSeccmp: []\n": `line 2: unknown key "Seccmp"`,
+		"CapabilitiesAdd:\n  - NET_ADMN\n": `line 2: invalid capability "NET_ADMN"`,
+		"Seccomp: [Default]\n": `line 1: invalid seccomp type "Default"`,
+		"AllowedVolumes: [Hostpath]\n": `(did you mean HostPath?)`,
+		"ProcMount: Masked\n": `line 1: invalid proc mount type "Masked"`,
+		"Exemptions:\n  - Name: cni\n    Rules: [privilege]\n": `line 3: invalid rule "privilege"`,
+		"RunAsUser: maybe\n": `line 1: cannot unmarshal`,
+		"DisabledRules: [run-as-users]\n": `line 1: invalid rule "run-as-users"`,
+		"CustomRules:\n  - ID: registry\n    Expression: container.image.startsWith(\n": `line 3: invalid Expression`,
+		"CustomRules:\n  - ID: privileged\n    Expression: \"true\"\n": `line 2: rule "privileged" is already defined`,
+		"CustomRules:\n  - ID: registry\n    Scope: Containers\n    Expression: \"true\"\n": `line 3: invalid scope "Containers"`,
+		"CustomRules:\n  - ID: registry\n    Expression: \"true\"\n    Patch:\n      - op: set\n": `line 5: invalid operation "set"`,
+		"Workloads:\n  - Kind: Rollout\n    PodTemplates: [spec.template]\n": `line 2: Workloads items need an APIVersion and a Kind`,
+		"Workloads:\n  - APIVersion: argoproj.io/v1alpha1\n    Kind: Rollout\n": `line 2: Workloads Rollout needs PodSpecs or PodTemplates`,
+		"Workloads:\n  - APIVersion: argoproj.io/v1alpha1\n    Kind: Rollout\n    PodTemplates: [spec..template]\n": `line 4: invalid path "spec..template"`,
+		"Workloads:\n  - APIVersion: argoproj.io/v1alpha1\n    Kind: Rollout\n    PodTemplate: [spec.template]\n": `unknown key "PodTemplate" in Workloads (did you mean PodTemplates?)`,
+		"Severities:\n  privileged: severe\n": `line 2: invalid severity "severe" in Severities`,
+		"Severities:\n  privilege: high\n": `line 2: invalid rule "privilege" in Severities (did you mean privileged?)`,
+	}
+
+	for data, expected := range(cases) {
+		_, err := Parse([]byte(data))
+		if err == nil {
+			t.Fatalf("TestParseInvalid accepted %q", data)
+		}
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("TestParseInvalid returned %q for %q", err.Error(), data)
+		}
+	}
+}
+
+func TestParseCustomRules(t *testing.T) {
+	data := `
+CustomRules:
+  - ID: registry
+    Scope: Container
+    Expression: container.image.startsWith('registry.corp/')
+    Message: image must come from registry.corp
+    Severity: high
+Exemptions:
+  - Namespace: kube-system
+    Rules: [registry]
+DisabledRules: [registry]
+`
+	pol, err := Parse([]byte(data))
+	if err != nil {
+		t.Fatalf("TestParseCustomRules returned %v", err)
+	}
+	if len(pol.CustomRules) != 1 || pol.CustomRules[0].Severity != "high" || pol.RuleEnabled("registry") {
+		t.Fatalf("TestParseCustomRules returned %v", pol.CustomRules)
+	}
+	if _, err := pol.CustomRules[0].Program(); err != nil {
+		t.Fatalf("TestParseCustomRules could not compile the expression: %v", err)
+	}
+}
+
+func TestLoadExtends(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	child := filepath.Join(dir, "child.yaml")
+
+	os.WriteFile(base, []byte("Extends: restricted\nCapabilitiesAdd:\n  - NET_ADMIN\n"), 0644)
+	os.WriteFile(child, []byte("Extends: base.yaml\nAllowedVolumes:\n  - HostPath\nDisallowedVolumes: []\nCapabilitiesAdd:\n  - SYS_TIME\nMerge:\n  - AllowedVolumes\n"), 0644)
+
+	pol, err := Load(child)
+	if err != nil {
+		t.Fatalf("TestLoadExtends returned %v", err)
+	}
+
+	restricted, _ := Builtin("restricted")
+	if pol.RunAsNonRoot != true || pol.Privileged != false {
+		t.Fatalf("TestLoadExtends did not inherit the restricted policy")
+	}
+	if len(pol.CapabilitiesAdd) != 1 || pol.CapabilitiesAdd[0] != "SYS_TIME" {
+		t.Fatalf("TestLoadExtends did not replace CapabilitiesAdd: %v", pol.CapabilitiesAdd)
+	}
+	if len(pol.AllowedVolumes) != len(restricted.AllowedVolumes) + 1 || pol.AllowedVolumes[len(pol.AllowedVolumes)-1] != "HostPath" {
+		t.Fatalf("TestLoadExtends did not merge AllowedVolumes: %v", pol.AllowedVolumes)
+	}
+	if len(pol.DisallowedVolumes) != 0 {
+		t.Fatalf("TestLoadExtends did not replace DisallowedVolumes: %v", pol.DisallowedVolumes)
+	}
+}
+
+func TestLoadSeverities(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.yaml")
+	child := filepath.Join(dir, "child.yaml")
+
+	os.WriteFile(base, []byte("Extends: restricted\nSeverities:\n  seccomp: high\n  run-as-user: low\n"), 0644)
+	os.WriteFile(child, []byte("Extends: base.yaml\nSeverities:\n  run-as-user: medium\n"), 0644)
+
+	pol, err := Load(child)
+	if err != nil {
+		t.Fatalf("TestLoadSeverities returned %v", err)
+	}
+	if pol.Severities[RuleSeccomp] != "high" || pol.Severities[RuleRunAsUser] != "medium" {
+		t.Fatalf("TestLoadSeverities did not merge the severities: %v", pol.Severities)
+	}
+	if !SeverityAtLeast("critical", "high") || SeverityAtLeast("medium", "high") || SeverityAtLeast("", "low") {
+		t.Fatalf("TestLoadSeverities compared the severities wrongly")
+	}
+}
+
+func TestLoadExtendsCycle(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("Extends: b.yaml\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("Extends: a.yaml\n"), 0644)
+
+	if _, err := Load(filepath.Join(dir, "a.yaml")); err == nil || !strings.Contains(err.Error(), "extends itself") {
+		t.Fatalf("TestLoadExtendsCycle returned %v", err)
+	}
+}
@@ -1,18 +1,83 @@
 package policy
 
+import "edurra/manifest-hardening/internal/utils"
+
+// Rule identifiers, used to reference checks from exemptions
+const (
+	RuleHostPID = "host-pid"
+	RuleHostNetwork = "host-network"
+	RuleHostIPC = "host-ipc"
+	RuleVolumes = "volumes"
+	RuleHostProcess = "host-process"
+	RulePrivileged = "privileged"
+	RuleCapabilitiesAdd = "capabilities-add"
+	RuleCapabilitiesDrop = "capabilities-drop"
+	RuleProcMount = "proc-mount"
+	RuleSeccomp = "seccomp"
+	RuleAllowPrivilegeEscalation = "allow-privilege-escalation"
+	RuleRunAsNonRoot = "run-as-non-root"
+	RuleRunAsUser = "run-as-user"
+	RuleDefaultServiceAccount = "default-service-account"
+	RuleAutomountServiceAccountToken = "automount-service-account-token"
+)
+
+// SeverityLevels are the severities of the findings, from the lowest to the highest
+var SeverityLevels = []string{"low", "medium", "high", "critical"}
+
+// SeverityAtLeast returns true if the severity is the threshold or a higher one. Findings without
+// a severity are below every threshold
+func SeverityAtLeast(severity string, threshold string) (bool) {
+	rank := func(s string) (int) {
+		for i, level := range(SeverityLevels) {
+			if level == s {
+				return i
+			}
+		}
+		return -1
+	}
+	return rank(severity) >= 0 && rank(severity) >= rank(threshold)
+}
+
+// Exemption skips the listed rules for the matching workloads and containers.
+// Empty fields match any value, and Name, Namespace, Container and Image accept glob patterns
+type Exemption struct {
+	Name string `yaml:"Name"` // name of the workload
+	Namespace string `yaml:"Namespace"` // namespace of the workload
+	Container string `yaml:"Container"` // name of the container. If set, pod level rules are not exempted
+	Image string `yaml:"Image"` // image of the container. If set, pod level rules are not exempted
+	Rules []string `yaml:"Rules"` // rules to skip
+}
+
 type Policy struct {
-	HostPID bool // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
-	HostNetwork bool // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
-	HostIPC bool // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
-	Privileged bool // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
-	HostProcess bool // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
-	CapabilitiesAdd []string // only included values are allowed, ALL can be included
-	CapabilitiesDrop []string // included values are disallowed, ALL can be included
-	ProcMount string // this value is the only one allowed
-	Seccomp []string // only values included are allowed. Need to add "Undefined" if empty seccomp profiles are allowed
-	AllowedVolumes []string // only included values are allowed, * can be included
-	DisallowedVolumes []string // included volumes are disallowed
-	AllowPrivilegeEscalation bool // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
-	RunAsNonRoot bool // if true, only "true" is allowed. If "false", "true", "false" ,or nil are allowed
-	RunAsUser bool // If true, a random value will be assigned. If false, the current value will be kept
-}
\ No newline at end of file
+	HostPID bool `yaml:"HostPID"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	HostNetwork bool `yaml:"HostNetwork"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	HostIPC bool `yaml:"HostIPC"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	Privileged bool `yaml:"Privileged"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	HostProcess bool `yaml:"HostProcess"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	CapabilitiesAdd []string `yaml:"CapabilitiesAdd"` // only included values are allowed, ALL can be included
+	CapabilitiesDrop []string `yaml:"CapabilitiesDrop"` // included values are disallowed, ALL can be included
+	ProcMount string `yaml:"ProcMount"` // this value is the only one allowed
+	Seccomp []string `yaml:"Seccomp"` // only values included are allowed. Need to add "Undefined" if empty seccomp profiles are allowed
+	AllowedVolumes []string `yaml:"AllowedVolumes"` // only included values are allowed, * can be included
+	DisallowedVolumes []string `yaml:"DisallowedVolumes"` // included volumes are disallowed
+	AllowPrivilegeEscalation bool `yaml:"AllowPrivilegeEscalation"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	RunAsNonRoot bool `yaml:"RunAsNonRoot"` // if true, only "true" is allowed. If "false", "true", "false" ,or nil are allowed
+	RunAsUser bool `yaml:"RunAsUser"` // If true, a non-root uid derived from the namespace and name of the workload will be assigned. If false, the current value will be kept
+	AutomountServiceAccountToken bool `yaml:"AutomountServiceAccountToken"` // if true, both "true" and "false" are allowed. If "false", only "false" is allowed
+	AutomountServiceAccountTokenExemptions []string `yaml:"AutomountServiceAccountTokenExemptions"` // service accounts that are allowed to automount their token
+	DefaultServiceAccount bool `yaml:"DefaultServiceAccount"` // if true, the default service account is allowed. If false, a dedicated service account is generated for the workload
+	Exemptions []Exemption `yaml:"Exemptions"` // rules skipped for matching workloads and containers
+	EnabledRules []string `yaml:"EnabledRules"` // if set, only these rules are evaluated
+	DisabledRules []string `yaml:"DisabledRules"` // rules that are never evaluated
+	CustomRules []CustomRule `yaml:"CustomRules"` // CEL rules evaluated after the built-in ones
+	Workloads []Workload `yaml:"Workloads"` // custom resources hardened through the pod specs they embed
+	Severities map[string]string `yaml:"Severities"` // severity of the findings of each rule, overriding the default of the rule. Merged with the base policy
+}
+
+// RuleEnabled returns true if the rule is evaluated with the policy, according to EnabledRules and DisabledRules
+func (p Policy) RuleEnabled(rule string) (bool) {
+	if len(p.EnabledRules) > 0 && !utils.ContainsValue(p.EnabledRules, rule) {
+		return false
+	}
+	return !utils.ContainsValue(p.DisabledRules, rule)
+}
@@ -0,0 +1,139 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pod Security Admission labels of the Namespace objects
+const (
+	EnforceLabel = "pod-security.kubernetes.io/enforce"
+	EnforceVersionLabel = "pod-security.kubernetes.io/enforce-version"
+)
+
+var enforceVersion = regexp.MustCompile(`^(latest|v1\.[0-9]+)$`)
+
+// NamespaceMapping is the content of a namespace mapping file
+type NamespaceMapping struct {
+	Default string `yaml:"Default"` // policy of the namespaces that are not listed
+	Namespaces map[string]string `yaml:"Namespaces"` // policy name or path (relative to the mapping file) of each namespace
+}
+
+// Selector picks the policy of each object from its namespace. The namespace mapping file takes precedence
+// over the Pod Security Admission labels of the Namespace objects, which take precedence over the default policy.
+// Select can be called concurrently once the namespaces are added
+type Selector struct {
+	mu sync.Mutex
+	fallback string
+	mapping NamespaceMapping
+	dir string
+	labels map[string]string
+	versions map[string]string
+	warnings []string
+	cache map[string]Policy
+}
+
+// NewSelector returns a selector with the given default policy and namespace mapping file. Both are optional
+func NewSelector(fallback string, mappingFile string) (*Selector, error) {
+	s := &Selector{
+		fallback: fallback,
+		dir: ".",
+		labels: map[string]string{},
+		versions: map[string]string{},
+		cache: map[string]Policy{},
+	}
+
+	if mappingFile != "" {
+		data, err := os.ReadFile(mappingFile)
+		if err != nil {
+			return nil, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&s.mapping); err != nil {
+			return nil, fmt.Errorf("%v: %w", mappingFile, err)
+		}
+		s.dir = filepath.Dir(mappingFile)
+	}
+
+	return s, nil
+}
+
+// AddNamespace registers the Pod Security Admission level enforced in a Namespace object. The built-in
+// policies implement the latest version of the Pod Security Standards, so a namespace pinned to another
+// version with the enforce-version label gets the latest controls, which is reported in Warnings
+func (s *Selector) AddNamespace(ns *corev1.Namespace) (error) {
+	level, ok := ns.Labels[EnforceLabel]
+	if !ok {
+		return nil
+	}
+	if _, ok := Builtin(level); !ok {
+		return fmt.Errorf("Namespace %v: invalid %v label %q", ns.Name, EnforceLabel, level)
+	}
+	version := ns.Labels[EnforceVersionLabel]
+	if version != "" && !enforceVersion.MatchString(version) {
+		return fmt.Errorf("Namespace %v: invalid %v label %q", ns.Name, EnforceVersionLabel, version)
+	}
+	s.labels[ns.Name] = level
+	s.versions[ns.Name] = version
+	if version != "" && version != "latest" {
+		s.warnings = append(s.warnings, fmt.Sprintf("Namespace %v: %v %v is not supported, the latest version of the %v policy is used", ns.Name, EnforceVersionLabel, version, level))
+	}
+	return nil
+}
+
+// Warnings returns the limitations of the policies selected for the added namespaces, in the order the
+// namespaces were added
+func (s *Selector) Warnings() ([]string) {
+	return s.warnings
+}
+
+// Select returns the policy of the given namespace and a description of where it comes from
+func (s *Selector) Select(namespace string) (Policy, string, error) {
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	var name, source string
+	if p, ok := s.mapping.Namespaces[namespace]; ok {
+		name, source = s.mappingPath(p), "namespace mapping"
+	} else if level, ok := s.labels[namespace]; ok {
+		name, source = level, "namespace label"
+		if version := s.versions[namespace]; version != "" && version != "latest" {
+			source = fmt.Sprintf("namespace label, enforce-version %v evaluated with the latest controls", version)
+		}
+	} else if s.mapping.Default != "" {
+		name, source = s.mappingPath(s.mapping.Default), "namespace mapping default"
+	} else if s.fallback != "" {
+		name, source = s.fallback, "policy flag"
+	} else {
+		return Policy{}, "", fmt.Errorf("No policy found for namespace %v", namespace)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pol, ok := s.cache[name]
+	if !ok {
+		var err error
+		if pol, err = Resolve(name); err != nil {
+			return Policy{}, "", err
+		}
+		s.cache[name] = pol
+	}
+
+	return pol, fmt.Sprintf("%v (%v)", name, source), nil
+}
+
+// mappingPath resolves the policy files of the mapping relative to the mapping file
+func (s *Selector) mappingPath(p string) (string) {
+	if _, ok := Builtin(p); ok || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(s.dir, p)
+}
@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSelector(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "system.yaml"), []byte("Extends: baseline\nHostNetwork: true\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "mapping.yaml"), []byte("Namespaces:\n  kube-system: system.yaml\n  app: baseline\n"), 0644)
+
+	selector, err := NewSelector("restricted", filepath.Join(dir, "mapping.yaml"))
+	if err != nil {
+		t.Fatalf("TestSelector returned %v", err)
+	}
+
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{EnforceLabel: "restricted"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "monitoring", Labels: map[string]string{EnforceLabel: "privileged", EnforceVersionLabel: "latest"}}},
+	}
+	for _, ns := range(namespaces) {
+		if err := selector.AddNamespace(ns); err != nil {
+			t.Fatalf("TestSelector returned %v", err)
+		}
+	}
+
+	cases := map[string]string{
+		"kube-system": "system.yaml (namespace mapping)",
+		"app": "baseline (namespace mapping)",
+		"monitoring": "privileged (namespace label)",
+		"": "restricted (policy flag)",
+	}
+	for namespace, expected := range(cases) {
+		_, source, err := selector.Select(namespace)
+		if err != nil {
+			t.Fatalf("TestSelector returned %v for namespace %v", err, namespace)
+		}
+		if !strings.HasSuffix(source, expected) {
+			t.Fatalf("TestSelector returned %v for namespace %v", source, namespace)
+		}
+	}
+
+	pol, _, _ := selector.Select("kube-system")
+	if pol.HostNetwork != true || pol.HostPID != false {
+		t.Fatalf("TestSelector did not load the mapped policy file")
+	}
+}
+
+func TestSelectorInvalidLabel(t *testing.T) {
+	selector, _ := NewSelector("", "")
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{EnforceLabel: "strict"}}}
+
+	if err := selector.AddNamespace(ns); err == nil {
+		t.Fatalf("TestSelectorInvalidLabel accepted an invalid level")
+	}
+	if _, _, err := selector.Select("app"); err == nil {
+		t.Fatalf("TestSelectorInvalidLabel selected a policy without default")
+	}
+}
+
+func TestSelectorEnforceVersion(t *testing.T) {
+	selector, _ := NewSelector("", "")
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "latest", Labels: map[string]string{EnforceLabel: "baseline", EnforceVersionLabel: "latest"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "unpinned", Labels: map[string]string{EnforceLabel: "baseline"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Labels: map[string]string{EnforceLabel: "restricted", EnforceVersionLabel: "v1.24"}}},
+	}
+	for _, ns := range(namespaces) {
+		if err := selector.AddNamespace(ns); err != nil {
+			t.Fatalf("TestSelectorEnforceVersion returned %v", err)
+		}
+	}
+
+	// only the pinned version is reported, it gets the latest controls
+	warnings := selector.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "legacy") || !strings.Contains(warnings[0], "v1.24") {
+		t.Fatalf("TestSelectorEnforceVersion returned the warnings %v", warnings)
+	}
+	pol, source, err := selector.Select("legacy")
+	if restricted, _ := Builtin("restricted"); err != nil || pol.RunAsNonRoot != restricted.RunAsNonRoot || !strings.Contains(source, "v1.24") {
+		t.Fatalf("TestSelectorEnforceVersion returned %v, %v", source, err)
+	}
+
+	bad := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bad", Labels: map[string]string{EnforceLabel: "baseline", EnforceVersionLabel: "1.24"}}}
+	if err := selector.AddNamespace(bad); err == nil {
+		t.Fatalf("TestSelectorEnforceVersion accepted an invalid version")
+	}
+}
@@ -0,0 +1,353 @@
+package policy
+
+import (
+	"edurra/manifest-hardening/internal/utils"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var capabilities = []string{
+	"ALL", "AUDIT_CONTROL", "AUDIT_READ", "AUDIT_WRITE", "BLOCK_SUSPEND", "BPF", "CHECKPOINT_RESTORE", "CHOWN",
+	"DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID", "IPC_LOCK", "IPC_OWNER", "KILL", "LEASE",
+	"LINUX_IMMUTABLE", "MAC_ADMIN", "MAC_OVERRIDE", "MKNOD", "NET_ADMIN", "NET_BIND_SERVICE", "NET_BROADCAST",
+	"NET_RAW", "PERFMON", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_ADMIN", "SYS_BOOT", "SYS_CHROOT",
+	"SYS_MODULE", "SYS_NICE", "SYS_PACCT", "SYS_PTRACE", "SYS_RAWIO", "SYS_RESOURCE", "SYS_TIME",
+	"SYS_TTY_CONFIG", "SYSLOG", "WAKE_ALARM",
+}
+
+var seccompTypes = []string{"RuntimeDefault", "Localhost", "Unconfined", "Undefined"}
+
+var procMountTypes = []string{"", "Default", "Unmasked"}
+
+// ruleIDs are the IDs of the built-in and registered rules, guarded by ruleIDsMu
+var (
+	ruleIDs = []string{
+		RuleHostPID, RuleHostNetwork, RuleHostIPC, RuleVolumes, RuleHostProcess, RulePrivileged, RuleCapabilitiesAdd,
+		RuleCapabilitiesDrop, RuleProcMount, RuleSeccomp, RuleAllowPrivilegeEscalation, RuleRunAsNonRoot, RuleRunAsUser,
+		RuleDefaultServiceAccount, RuleAutomountServiceAccountToken,
+	}
+	ruleIDsMu sync.RWMutex
+)
+
+// AddRuleID makes a custom rule known to the validation of policy files. It can be called concurrently
+// with the validation
+func AddRuleID(id string) {
+	ruleIDsMu.Lock()
+	defer ruleIDsMu.Unlock()
+	if !utils.ContainsValue(ruleIDs, id) {
+		ruleIDs = append(ruleIDs, id)
+	}
+}
+
+// knownRuleIDs returns a copy of the IDs of the built-in and registered rules
+func knownRuleIDs() ([]string) {
+	ruleIDsMu.RLock()
+	defer ruleIDsMu.RUnlock()
+	return append([]string{}, ruleIDs...)
+}
+
+// volumeTypes returns the volume type names, i.e. the fields of corev1.VolumeSource
+func volumeTypes() ([]string) {
+	result := []string{}
+	t := reflect.TypeOf(corev1.VolumeSource{})
+	for i := 0; i < t.NumField(); i++ {
+		result = append(result, t.Field(i).Name)
+	}
+	return result
+}
+
+// listFields returns the names of the list fields of the policy, which can be merged with the base policy
+func listFields() ([]string) {
+	result := []string{}
+	t := reflect.TypeOf(Policy{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Slice {
+			result = append(result, t.Field(i).Name)
+		}
+	}
+	return result
+}
+
+// yamlKeys returns the keys accepted for a struct decoded from a policy file
+func yamlKeys(v interface{}) ([]string) {
+	result := []string{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		if key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; key != "" {
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+type valueValidator func(node *yaml.Node, key string) ([]error)
+
+func enumList(kind string, allowed []string) (valueValidator) {
+	return func(node *yaml.Node, key string) ([]error) {
+		var errs []error
+		if node.Kind != yaml.SequenceNode {
+			return errs
+		}
+		for _, item := range(node.Content) {
+			errs = append(errs, checkEnum(item, key, kind, allowed)...)
+		}
+		return errs
+	}
+}
+
+func enumScalar(kind string, allowed []string) (valueValidator) {
+	return func(node *yaml.Node, key string) ([]error) {
+		return checkEnum(node, key, kind, allowed)
+	}
+}
+
+func checkEnum(node *yaml.Node, key string, kind string, allowed []string) ([]error) {
+	if node.Kind != yaml.ScalarNode || utils.ContainsValue(allowed, node.Value) {
+		return nil
+	}
+	return []error{fmt.Errorf("line %d: invalid %s %q in %s%s", node.Line, kind, node.Value, key, suggestion(node.Value, allowed))}
+}
+
+func validateExemptions(rules []string) (valueValidator) {
+	return func(node *yaml.Node, key string) ([]error) {
+		var errs []error
+		if node.Kind != yaml.SequenceNode {
+			return errs
+		}
+		for _, item := range(node.Content) {
+			errs = append(errs, validateMapping(item, key, yamlKeys(Exemption{}), map[string]valueValidator{
+				"Rules": enumList("rule", rules),
+			})...)
+		}
+		return errs
+	}
+}
+
+func validateSeverities(rules []string) (valueValidator) {
+	return func(node *yaml.Node, key string) ([]error) {
+		var errs []error
+		if node.Kind != yaml.MappingNode {
+			return errs
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, checkEnum(node.Content[i], key, "rule", rules)...)
+			errs = append(errs, checkEnum(node.Content[i+1], key, "severity", SeverityLevels)...)
+		}
+		return errs
+	}
+}
+
+func validateCustomRules(node *yaml.Node, key string) ([]error) {
+	var errs []error
+	if node.Kind != yaml.SequenceNode {
+		return errs
+	}
+	seen := map[string]bool{}
+	for _, item := range(node.Content) {
+		errs = append(errs, validateMapping(item, key, yamlKeys(CustomRule{}), map[string]valueValidator{
+			"Scope": enumScalar("scope", scopes),
+			"Severity": enumScalar("severity", severities),
+			"Expression": validateExpression,
+			"Patch": validatePatch,
+		})...)
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		id := mappingValue(item, "ID")
+		switch {
+			case id == nil || id.Value == "" || mappingValue(item, "Expression") == nil:
+				errs = append(errs, fmt.Errorf("line %d: %s items need an ID and an Expression", item.Line, key))
+			case utils.ContainsValue(knownRuleIDs(), id.Value):
+				errs = append(errs, fmt.Errorf("line %d: rule %q is already defined", id.Line, id.Value))
+			case seen[id.Value]:
+				errs = append(errs, fmt.Errorf("line %d: duplicated rule %q in %s", id.Line, id.Value, key))
+		}
+		if id != nil {
+			seen[id.Value] = true
+		}
+	}
+	return errs
+}
+
+func validateExpression(node *yaml.Node, key string) ([]error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil
+	}
+	if _, err := (CustomRule{Expression: node.Value}).Program(); err != nil {
+		return []error{fmt.Errorf("line %d: invalid %s: %v", node.Line, key, err)}
+	}
+	return nil
+}
+
+func validatePatch(node *yaml.Node, key string) ([]error) {
+	var errs []error
+	if node.Kind != yaml.SequenceNode {
+		return errs
+	}
+	for _, item := range(node.Content) {
+		errs = append(errs, validateMapping(item, key, yamlKeys(PatchOperation{}), map[string]valueValidator{
+			"op": enumScalar("operation", patchOperations),
+		})...)
+	}
+	return errs
+}
+
+func validateWorkloads(node *yaml.Node, key string) ([]error) {
+	var errs []error
+	if node.Kind != yaml.SequenceNode {
+		return errs
+	}
+	seen := map[string]bool{}
+	for _, item := range(node.Content) {
+		errs = append(errs, validateMapping(item, key, yamlKeys(Workload{}), map[string]valueValidator{
+			"PodSpecs": validatePaths,
+			"PodTemplates": validatePaths,
+		})...)
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		apiVersion, kind := mappingValue(item, "APIVersion"), mappingValue(item, "Kind")
+		if apiVersion == nil || kind == nil || apiVersion.Value == "" || kind.Value == "" {
+			errs = append(errs, fmt.Errorf("line %d: %s items need an APIVersion and a Kind", item.Line, key))
+			continue
+		}
+		if mappingValue(item, "PodSpecs") == nil && mappingValue(item, "PodTemplates") == nil {
+			errs = append(errs, fmt.Errorf("line %d: %s %v needs PodSpecs or PodTemplates", item.Line, key, kind.Value))
+		}
+		gvk := apiVersion.Value + "/" + kind.Value
+		if seen[gvk] {
+			errs = append(errs, fmt.Errorf("line %d: duplicated kind %v in %s", kind.Line, gvk, key))
+		}
+		seen[gvk] = true
+	}
+	return errs
+}
+
+func validatePaths(node *yaml.Node, key string) ([]error) {
+	var errs []error
+	if node.Kind != yaml.SequenceNode {
+		return errs
+	}
+	for _, item := range(node.Content) {
+		if utils.ContainsValue(SplitPath(item.Value), "") {
+			errs = append(errs, fmt.Errorf("line %d: invalid path %q in %s", item.Line, item.Value, key))
+		}
+	}
+	return errs
+}
+
+// mappingValue returns the value of a key of a mapping node, or nil if it is not set
+func mappingValue(node *yaml.Node, key string) (*yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// documentRuleIDs returns the IDs of the custom rules defined in a policy document
+func documentRuleIDs(root *yaml.Node) ([]string) {
+	var result []string
+	if root.Content[0].Kind != yaml.MappingNode {
+		return result
+	}
+	rules := mappingValue(root.Content[0], "CustomRules")
+	if rules == nil || rules.Kind != yaml.SequenceNode {
+		return result
+	}
+	for _, item := range(rules.Content) {
+		if id := mappingValue(item, "ID"); item.Kind == yaml.MappingNode && id != nil {
+			result = append(result, id.Value)
+		}
+	}
+	return result
+}
+
+func validateMapping(node *yaml.Node, context string, keys []string, validators map[string]valueValidator) ([]error) {
+	var errs []error
+	if node.Kind != yaml.MappingNode {
+		return []error{fmt.Errorf("line %d: %s must be a mapping", node.Line, context)}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !utils.ContainsValue(keys, key.Value) {
+			errs = append(errs, fmt.Errorf("line %d: unknown key %q in %s%s", key.Line, key.Value, context, suggestion(key.Value, keys)))
+			continue
+		}
+		if validate, ok := validators[key.Value]; ok {
+			errs = append(errs, validate(value, key.Value)...)
+		}
+	}
+	return errs
+}
+
+// validateDocument checks the keys and enum values of a policy document, reporting every error with its line.
+// baseRules are the custom rules of the base policy, which can be referenced by the document
+func validateDocument(root *yaml.Node, baseRules []string) (error) {
+	if root.Kind == 0 || len(root.Content) == 0 {
+		return nil
+	}
+
+	volumes := volumeTypes()
+	rules := append(append(knownRuleIDs(), baseRules...), documentRuleIDs(root)...)
+	errs := validateMapping(root.Content[0], "policy", append(yamlKeys(Policy{}), "Extends", "Merge"), map[string]valueValidator{
+		"Merge": enumList("list field", listFields()),
+		"CapabilitiesAdd": enumList("capability", capabilities),
+		"CapabilitiesDrop": enumList("capability", capabilities),
+		"ProcMount": enumScalar("proc mount type", procMountTypes),
+		"Seccomp": enumList("seccomp type", seccompTypes),
+		"AllowedVolumes": enumList("volume type", append([]string{"*"}, volumes...)),
+		"DisallowedVolumes": enumList("volume type", volumes),
+		"Exemptions": validateExemptions(rules),
+		"EnabledRules": enumList("rule", rules),
+		"DisabledRules": enumList("rule", rules),
+		"CustomRules": validateCustomRules,
+		"Workloads": validateWorkloads,
+		"Severities": validateSeverities(rules),
+	})
+
+	return errors.Join(errs...)
+}
+
+// suggestion returns a hint with the closest candidate, if any is close enough
+func suggestion(value string, candidates []string) (string) {
+	best, bestDistance := "", 3
+	for _, c := range(candidates) {
+		if strings.EqualFold(c, value) {
+			return fmt.Sprintf(" (did you mean %s?)", c)
+		}
+		if d := levenshtein(strings.ToLower(value), strings.ToLower(c)); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+func levenshtein(a string, b string) (int) {
+	previous := make([]int, len(b)+1)
+	for j := range(previous) {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,27 @@
+package policy
+
+import "strings"
+
+// Workload locates the pod specs embedded in the objects of a custom resource kind, e.g. Argo Rollouts
+// or KEDA ScaledJobs. Paths are dot separated field names, e.g. spec.jobTargetRef.template
+type Workload struct {
+	APIVersion string `yaml:"APIVersion"` // group/version of the custom resource, e.g. argoproj.io/v1alpha1
+	Kind string `yaml:"Kind"`
+	PodSpecs []string `yaml:"PodSpecs"` // paths of corev1.PodSpec fields
+	PodTemplates []string `yaml:"PodTemplates"` // paths of corev1.PodTemplateSpec fields, whose annotations are read for exemptions
+}
+
+// Workload returns the pod spec paths of a custom resource kind, if they are configured in the policy
+func (p Policy) Workload(apiVersion string, kind string) (Workload, bool) {
+	for _, w := range(p.Workloads) {
+		if w.APIVersion == apiVersion && w.Kind == kind {
+			return w, true
+		}
+	}
+	return Workload{}, false
+}
+
+// SplitPath returns the field names of a path, which may start with a dot as in kubectl
+func SplitPath(path string) ([]string) {
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
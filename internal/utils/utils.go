@@ -1,41 +1,228 @@
 package utils
 
 import (
+	"hash/fnv"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime"
-	"io/ioutil"
+	"bufio"
+	"bytes"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/cli-runtime/pkg/printers"
 	"os"
+	"path/filepath"
 	"reflect"
-	"math/rand"
 	"io"
 	"errors"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	stdjson "encoding/json"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
-func ReadFromPipe() (runtime.Object, *schema.GroupVersionKind, error){
+// Document is a decoded object of the input stream
+type Document struct {
+	Object runtime.Object
+	GVK *schema.GroupVersionKind
+}
+
+// Formats of the input and output manifests
+const (
+	FormatAuto = "auto"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// Formats lists the accepted values of the format flags
+var Formats = []string{FormatAuto, FormatYAML, FormatJSON}
+
+func ReadFromPipe(format string) ([]Document, string, error){
 	stat, _ := os.Stdin.Stat()
 
 	if (stat.Mode() & os.ModeCharDevice) != 0 {
-		return &corev1.Pod{}, nil, errors.New("No input provided")
+		return nil, "", errors.New("No input provided")
+	}
+
+	return DecodeDocumentsFormat(os.Stdin, format)
+}
+
+// DecodeDocuments decodes every document of a multi-document YAML or JSON stream, skipping empty documents
+func DecodeDocuments(r io.Reader) ([]Document, error) {
+	documents, _, err := DecodeDocumentsFormat(r, FormatAuto)
+	return documents, err
+}
+
+// DecodeDocumentsFormat decodes a multi-document YAML stream or a stream of JSON objects. With FormatAuto,
+// the format is detected from the first character of the stream. The items of List objects
+// (e.g. the output of `kubectl get -o json`) are decoded as separate documents. It returns the format of the stream
+func DecodeDocumentsFormat(r io.Reader, format string) ([]Document, string, error) {
+	var documents []Document
+	buffered := bufio.NewReader(r)
+
+	if format == FormatAuto || format == "" {
+		format = detectFormat(buffered)
+	}
+
+	var read func() ([]byte, error)
+	if format == FormatJSON {
+		decoder := yaml.NewYAMLOrJSONDecoder(buffered, 4096)
+		read = func() ([]byte, error) {
+			var raw stdjson.RawMessage
+			err := decoder.Decode(&raw)
+			return raw, err
+		}
+	} else {
+		reader := yaml.NewYAMLReader(buffered)
+		read = reader.Read
+	}
+
+	for {
+		data, err := read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return documents, format, err
+		}
+		if IsEmptyDocument(data) {
+			continue
+		}
+
+		decoded, err := DecodeDocument(data)
+		if err != nil {
+			return documents, format, err
+		}
+		documents = append(documents, decoded...)
+	}
+
+	return documents, format, nil
+}
+
+// detectFormat returns FormatJSON if the first character of the stream opens a JSON object or array
+func detectFormat(r *bufio.Reader) (string) {
+	for i := 1; ; i++ {
+		peek, err := r.Peek(i)
+		if err != nil {
+			return FormatYAML
+		}
+		switch peek[i-1] {
+			case ' ', '\t', '\r', '\n':
+				continue
+			case '{', '[':
+				return FormatJSON
+			default:
+				return FormatYAML
+		}
 	}
+}
 
-	data, err := io.ReadAll(os.Stdin)
+// DecodeDocument decodes a single YAML or JSON document, expanding the items of List objects.
+// Documents of kinds that are not registered in the client-go scheme, e.g. custom resources,
+// are decoded as *unstructured.Unstructured
+func DecodeDocument(data []byte) ([]Document, error) {
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 
+	obj, gKV, err := decode(data, nil, nil)
+	if runtime.IsNotRegisteredError(err) {
+		return decodeUnstructured(data)
+	}
 	if err != nil {
-		return &corev1.Pod{}, nil, err
+		return nil, err
 	}
 
-	obj, gKV, err := decode(data, nil, nil)
-	
+	list, ok := obj.(*corev1.List)
+	if !ok {
+		return []Document{{Object: obj, GVK: gKV}}, nil
+	}
+	var documents []Document
+	for _, item := range(list.Items) {
+		decoded, err := DecodeDocument(item.Raw)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, decoded...)
+	}
+	return documents, nil
+}
+
+// ToUnstructured converts a typed object, or a pointer to a struct of the API, to its unstructured content.
+// The empty fields added by the conversion (e.g. creationTimestamp: null, resources: {}) are dropped,
+// unless they are set in the original content, which can be nil
+func ToUnstructured(obj interface{}, original map[string]interface{}) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	pruneEmpty(content, original)
+	return content, nil
+}
+
+// pruneEmpty removes the nil values and empty maps of the content that are not set in the original.
+// The items of lists are matched by name, or by index if they have no name
+func pruneEmpty(content map[string]interface{}, original map[string]interface{}) {
+	for k, v := range(content) {
+		o, inOriginal := original[k]
+		switch value := v.(type) {
+			case map[string]interface{}:
+				originalMap, _ := o.(map[string]interface{})
+				pruneEmpty(value, originalMap)
+				if len(value) == 0 && !inOriginal {
+					delete(content, k)
+				}
+			case []interface{}:
+				originalList, _ := o.([]interface{})
+				for i, item := range(value) {
+					if m, ok := item.(map[string]interface{}); ok {
+						pruneEmpty(m, matchingItem(originalList, i, m))
+					}
+				}
+			case nil:
+				if !inOriginal {
+					delete(content, k)
+				}
+		}
+	}
+}
+
+// matchingItem returns the item of the original list with the same name as item, or at the same index
+func matchingItem(original []interface{}, i int, item map[string]interface{}) (map[string]interface{}) {
+	if name, ok := item["name"].(string); ok {
+		for _, o := range(original) {
+			if m, ok := o.(map[string]interface{}); ok && m["name"] == name {
+				return m
+			}
+		}
+		return nil
+	}
+	if i < len(original) {
+		m, _ := original[i].(map[string]interface{})
+		return m
+	}
+	return nil
+}
+
+func decodeUnstructured(data []byte) ([]Document, error) {
+	jsonData, err := yaml.ToJSON(data)
 	if err != nil {
-		return obj, gKV, err
+		return nil, err
 	}
+	obj, gKV, err := unstructured.UnstructuredJSONScheme.Decode(jsonData, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return []Document{{Object: obj, GVK: gKV}}, nil
+}
 
-	return obj, gKV, nil
+// IsEmptyDocument returns true if the document only contains separators and comments
+func IsEmptyDocument(data []byte) (bool) {
+	for _, line := range(bytes.Split(data, []byte("\n"))) {
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && !bytes.Equal(line, []byte("---")) && !bytes.HasPrefix(line, []byte("#")) {
+			return false
+		}
+	}
+	return true
 }
 
 func ObjToString(obj runtime.Object) (string, error) {
@@ -48,33 +235,116 @@ func ObjToString(obj runtime.Object) (string, error) {
 		return string(yamlBytes), nil
 	}
 }
-func ReadObject(filepath string)(runtime.Object, *schema.GroupVersionKind, error) {
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-	stream, err := ioutil.ReadFile(filepath)
+func ReadObjects(filepath string)([]Document, error) {
+	documents, _, err := ReadObjectsFormat(filepath, FormatAuto)
+	return documents, err
+}
+
+// ReadObjectsFormat reads the documents of a YAML or JSON file, returning the format of the file
+func ReadObjectsFormat(filepath string, format string) ([]Document, string, error) {
+	file, err := os.Open(filepath)
 
 	if err != nil {
-		return &corev1.Pod{}, nil, err
-	}
-	obj, gKV, err := decode(stream, nil, nil)
-	
-	if err != nil {
-		return obj, gKV, err
+		return nil, "", err
 	}
+	defer file.Close()
 
-	return obj, gKV, nil
+	return DecodeDocumentsFormat(file, format)
 }
 
-func WriteObject(filepath string, object runtime.Object) (error){
+// WriteObject writes the objects as YAML to a new file, or truncates the existing one
+func WriteObject(filepath string, objects ...runtime.Object) (error){
+	return WriteObjectFormat(filepath, FormatYAML, objects...)
+}
+
+// WriteObjectFormat writes the objects to a new file, or truncates the existing one
+func WriteObjectFormat(filepath string, format string, objects ...runtime.Object) (error){
 	newFile, err := os.Create(filepath)
 	if err != nil {
 		return err
 	}
 
+	if err := EncodeObjects(newFile, format, objects...); err != nil {
+		newFile.Close()
+		return err
+	}
+	return newFile.Close()
+}
+
+// EncodeObjects writes the objects as a multi-document YAML stream or, in JSON, as a single object
+// or a List of objects
+func EncodeObjects(w io.Writer, format string, objects ...runtime.Object) (error) {
+	if format == FormatJSON {
+		p := printers.JSONPrinter{}
+		if len(objects) == 1 {
+			return p.PrintObj(objects[0], w)
+		}
+		list := &corev1.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+		for _, object := range(objects) {
+			data, err := stdjson.Marshal(object)
+			if err != nil {
+				return err
+			}
+			list.Items = append(list.Items, runtime.RawExtension{Raw: data})
+		}
+		return p.PrintObj(list, w)
+	}
+
 	y := printers.YAMLPrinter{}
-	defer newFile.Close()
-	y.PrintObj(object, newFile)
-	
-	return err
+	for _, object := range(objects) {
+		if err := y.PrintObj(object, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceFile atomically replaces the content of an existing file, keeping its permissions. The data is written
+// to a temporary file in the same directory, which is renamed over the original. If backup is true, the original
+// content is kept in <name>.bak. A file that already has the data is left untouched, without backup
+func ReplaceFile(name string, data []byte, backup bool) (error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	original, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(original, data) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), "." + filepath.Base(name) + ".tmp-*")
+	if err != nil {
+		return err
+	}
+	// the temporary file is removed unless it has been renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if backup {
+		if err := os.WriteFile(name + ".bak", original, info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), name)
 }
 
 func ContainsValue(slice []string, value string) bool {
@@ -110,9 +380,12 @@ func VolumeIsDisallowed(volume corev1.Volume, disallowedVolumes []string) (bool)
 	return disallowed
 }
 
-func RandomUser() (int64) {
-	randNum := rand.Intn(65536)
-	return int64(randNum + 1)
+// StableUser returns a non-root uid derived from the namespace and name of a workload, so that hardening
+// the same workload always assigns the same uid
+func StableUser(namespace string, name string) (int64) {
+	h := fnv.New32a()
+	h.Write([]byte(namespace + "/" + name))
+	return int64(h.Sum32() % 65536) + 1
 }
 
 func CapabilityInList(caps []corev1.Capability, c string) (bool) {
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	stdjson "encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestReplaceFile(t *testing.T) {
+	for _, backup := range([]bool{false, true}) {
+		dir := t.TempDir()
+		name := filepath.Join(dir, "pod.yaml")
+		if err := os.WriteFile(name, []byte("original\n"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(name, 0640); err != nil {
+			t.Fatal(err)
+		}
+		before, _ := os.Stat(name)
+
+		if err := ReplaceFile(name, []byte("hardened\n"), backup); err != nil {
+			t.Fatalf("TestReplaceFile returned %v", err)
+		}
+
+		after, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the file is replaced by renaming the temporary file, not rewritten
+		if os.SameFile(before, after) || after.Mode().Perm() != 0640 {
+			t.Fatalf("TestReplaceFile did not replace the file keeping its mode: %v", after.Mode())
+		}
+		if data, _ := os.ReadFile(name); string(data) != "hardened\n" {
+			t.Fatalf("TestReplaceFile wrote %q", data)
+		}
+		data, err := os.ReadFile(name + ".bak")
+		if backup && (err != nil || string(data) != "original\n") {
+			t.Fatalf("TestReplaceFile wrote the backup %q: %v", data, err)
+		}
+		if !backup && !os.IsNotExist(err) {
+			t.Fatalf("TestReplaceFile wrote a backup without backup")
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != map[bool]int{false: 1, true: 2}[backup] {
+			t.Fatalf("TestReplaceFile left temporary files: %v", entries)
+		}
+	}
+}
+
+func TestReplaceFileUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "pod.yaml")
+	if err := os.WriteFile(name, []byte("hardened\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(name, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(name)
+
+	if err := ReplaceFile(name, []byte("hardened\n"), true); err != nil {
+		t.Fatalf("TestReplaceFileUnchanged returned %v", err)
+	}
+
+	after, _ := os.Stat(name)
+	if !os.SameFile(before, after) || !after.ModTime().Equal(modTime) {
+		t.Fatalf("TestReplaceFileUnchanged modified the file")
+	}
+	if _, err := os.Stat(name + ".bak"); !os.IsNotExist(err) {
+		t.Fatalf("TestReplaceFileUnchanged wrote a backup")
+	}
+}
+
+func TestReplaceFileMissing(t *testing.T) {
+	if err := ReplaceFile(filepath.Join(t.TempDir(), "missing.yaml"), []byte("hardened\n"), false); err == nil {
+		t.Fatalf("TestReplaceFileMissing did not return an error")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := map[string]string{
+		"{\"kind\": \"Pod\"}": FormatJSON,
+		"\n  \t[{\"kind\": \"Pod\"}]": FormatJSON,
+		"kind: Pod\n": FormatYAML,
+		"---\n{\"kind\": \"Pod\"}\n": FormatYAML,
+		"": FormatYAML,
+	}
+	for input, expected := range(tests) {
+		if format := detectFormat(bufio.NewReader(strings.NewReader(input))); format != expected {
+			t.Fatalf("TestDetectFormat returned %v for %q", format, input)
+		}
+	}
+}
+
+func TestDecodeDocumentsJSONStream(t *testing.T) {
+	stream := `{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web"}}
+{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api"}}
+{"apiVersion": "example.com/v1", "kind": "Job", "metadata": {"name": "batch"}}
+`
+	documents, format, err := DecodeDocumentsFormat(strings.NewReader(stream), FormatAuto)
+	if err != nil {
+		t.Fatalf("TestDecodeDocumentsJSONStream returned %v", err)
+	}
+	if format != FormatJSON || len(documents) != 3 {
+		t.Fatalf("TestDecodeDocumentsJSONStream returned %v documents in %v", len(documents), format)
+	}
+	if _, ok := documents[0].Object.(*corev1.Pod); !ok || documents[1].GVK.Kind != "Deployment" {
+		t.Fatalf("TestDecodeDocumentsJSONStream returned %v and %v", documents[0].GVK, documents[1].GVK)
+	}
+	if _, ok := documents[2].Object.(*unstructured.Unstructured); !ok {
+		t.Fatalf("TestDecodeDocumentsJSONStream did not decode the custom resource as unstructured")
+	}
+}
+
+func TestDecodeDocumentsList(t *testing.T) {
+	// the output of `kubectl get deployments,pods -o json`
+	list := `{
+    "apiVersion": "v1",
+    "kind": "List",
+    "items": [
+        {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api", "namespace": "prod"}},
+        {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web", "namespace": "prod"}}
+    ],
+    "metadata": {"resourceVersion": ""}
+}
+`
+	documents, format, err := DecodeDocumentsFormat(strings.NewReader(list), FormatAuto)
+	if err != nil {
+		t.Fatalf("TestDecodeDocumentsList returned %v", err)
+	}
+	if format != FormatJSON || len(documents) != 2 {
+		t.Fatalf("TestDecodeDocumentsList returned %v documents in %v", len(documents), format)
+	}
+	if deployment, ok := documents[0].Object.(*appsv1.Deployment); !ok || deployment.Name != "api" || documents[1].GVK.Kind != "Pod" {
+		t.Fatalf("TestDecodeDocumentsList returned %v and %v", documents[0].GVK, documents[1].GVK)
+	}
+}
+
+func TestEncodeObjectsJSON(t *testing.T) {
+	documents, err := DecodeDocument([]byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: web\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := documents[0].Object
+	serviceAccount := &corev1.ServiceAccount{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"}, ObjectMeta: metav1.ObjectMeta{Name: "web"}}
+
+	// a single object is written as is
+	var single bytes.Buffer
+	if err := EncodeObjects(&single, FormatJSON, pod); err != nil {
+		t.Fatalf("TestEncodeObjectsJSON returned %v", err)
+	}
+	var content map[string]interface{}
+	if err := stdjson.Unmarshal(single.Bytes(), &content); err != nil || content["kind"] != "Pod" {
+		t.Fatalf("TestEncodeObjectsJSON returned %v: %v", single.String(), err)
+	}
+
+	// several objects are written as a List, which is decoded back to the same objects
+	var multiple bytes.Buffer
+	if err := EncodeObjects(&multiple, FormatJSON, pod, serviceAccount); err != nil {
+		t.Fatalf("TestEncodeObjectsJSON returned %v", err)
+	}
+	content = nil
+	if err := stdjson.Unmarshal(multiple.Bytes(), &content); err != nil || content["kind"] != "List" || content["apiVersion"] != "v1" {
+		t.Fatalf("TestEncodeObjectsJSON returned %v: %v", multiple.String(), err)
+	}
+	decoded, format, err := DecodeDocumentsFormat(&multiple, FormatAuto)
+	if err != nil || format != FormatJSON || len(decoded) != 2 {
+		t.Fatalf("TestEncodeObjectsJSON decoded %v documents in %v: %v", len(decoded), format, err)
+	}
+	if account, ok := decoded[1].Object.(*corev1.ServiceAccount); !ok || account.Name != "web" || decoded[0].GVK.Kind != "Pod" {
+		t.Fatalf("TestEncodeObjectsJSON decoded %v and %v", decoded[0].GVK, decoded[1].GVK)
+	}
+}
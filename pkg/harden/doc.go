@@ -0,0 +1,18 @@
+// Package harden is the Go API of manifest-hardening. It hardens Deployments and Pods, given as typed
+// objects or as *unstructured.Unstructured, with the same policies and rules as the command line tool:
+//
+//	h, err := harden.New(harden.WithPolicy("restricted"), harden.WithoutRules("run-as-user"))
+//	result, err := h.Harden(deployment)
+//
+// Rules of other packages are added with Register, which is safe for concurrent use like Hardener.
+//
+// The module path, edurra/manifest-hardening, can't be downloaded by the go command, so programs of
+// other modules import this package from a local copy of the repository, with a replace directive:
+//
+//	require edurra/manifest-hardening v0.0.0
+//	replace edurra/manifest-hardening => ../manifest-hardening
+//
+// Unlike the packages under internal/, the exported identifiers of this package are only removed or
+// changed together with a note in the README. New options and rules may be added at any time, and the
+// messages of the findings may change
+package harden
@@ -0,0 +1,91 @@
+package harden_test
+
+import (
+	"edurra/manifest-hardening/pkg/harden"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func ExampleHarden() {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			HostNetwork: true,
+			Containers: []corev1.Container{{Name: "web", Image: "nginx"}},
+		},
+	}
+
+	result, err := harden.Harden(pod, harden.WithPolicy("baseline"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(result.Changed, result.Object.(*corev1.Pod).Spec.HostNetwork)
+	for _, f := range(result.Findings) {
+		fmt.Println(f.Rule)
+	}
+	// Output:
+	// true false
+	// host-network
+}
+
+func ExampleHardener_Harden_unstructured() {
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{"name": "web"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "web", "image": "nginx", "securityContext": map[string]interface{}{"privileged": true}},
+					},
+				},
+			},
+		},
+	}}
+
+	h, err := harden.New(harden.WithPolicy("restricted"), harden.WithRules("privileged", "seccomp"))
+	if err != nil {
+		panic(err)
+	}
+	result, err := h.Harden(deployment)
+	if err != nil {
+		panic(err)
+	}
+	hardened := result.Object.(*unstructured.Unstructured)
+	containers, _, _ := unstructured.NestedSlice(hardened.Object, "spec", "template", "spec", "containers")
+	fmt.Println(containers[0].(map[string]interface{})["securityContext"])
+	profile, _, _ := unstructured.NestedString(hardened.Object, "spec", "template", "spec", "securityContext", "seccompProfile", "type")
+	fmt.Println(profile)
+	// Output:
+	// map[privileged:false]
+	// RuntimeDefault
+}
+
+func ExampleWithFindingCallback() {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			HostPID: true,
+			HostIPC: true,
+			Containers: []corev1.Container{{Name: "web", Image: "nginx"}},
+		},
+	}
+
+	count := map[string]int{}
+	h, err := harden.New(
+		harden.WithPolicy("baseline"),
+		harden.WithFindingCallback(func(obj runtime.Object, f harden.Finding) {
+			count[f.Rule]++
+		}),
+	)
+	if err != nil {
+		panic(err)
+	}
+	if _, err := h.Harden(pod); err != nil {
+		panic(err)
+	}
+	fmt.Println(count)
+	// Output:
+	// map[host-ipc:1 host-pid:1]
+}
@@ -0,0 +1,173 @@
+package harden
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	"edurra/manifest-hardening/internal/policy"
+	"edurra/manifest-hardening/internal/utils"
+	"errors"
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// ErrUnsupportedKind is returned in strict mode for objects that can't be hardened
+var ErrUnsupportedKind = errors.New("unsupported kind")
+
+// Finding is the result of a rule for an object or one of its containers
+type Finding struct {
+	Rule string
+	Container string // empty for pod level findings
+	Message string
+	Exempted bool // the rule was skipped because of an exemption
+	Severity string // low, medium, high or critical, empty for exempted findings
+}
+
+// Result is a hardened object
+type Result struct {
+	// Object is the hardened copy of the object, of the same type: a typed object or *unstructured.Unstructured
+	Object runtime.Object
+	// ServiceAccount is the dedicated service account assigned to the object, which must be created with it.
+	// It is nil if the policy allows the default service account
+	ServiceAccount runtime.Object
+	Findings []Finding
+	// Changed is true if the hardened object differs from the original one or needs a service account
+	Changed bool
+}
+
+// Hardener hardens objects with a policy. It is safe for concurrent use
+type Hardener struct {
+	config config
+}
+
+// New returns a Hardener configured with the options
+func New(opts ...Option) (*Hardener, error) {
+	restricted, _ := policy.Builtin("restricted")
+	c := config{policy: restricted}
+	for _, opt := range(opts) {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.enabled != nil {
+		c.policy.EnabledRules = c.enabled
+	}
+	c.policy.DisabledRules = append(append([]string{}, c.policy.DisabledRules...), c.disabled...)
+
+	if c.strict {
+		rules, err := generator.EnabledRules(policy.Policy{CustomRules: c.policy.CustomRules})
+		if err != nil {
+			return nil, err
+		}
+		known := map[string]bool{}
+		for _, r := range(rules) {
+			known[r.ID()] = true
+		}
+		for _, id := range(append(append([]string{}, c.enabled...), c.disabled...)) {
+			if !known[id] {
+				return nil, fmt.Errorf("unknown rule %q", id)
+			}
+		}
+	}
+	return &Hardener{config: c}, nil
+}
+
+// Harden hardens a single object with the options, see Hardener.Harden
+func Harden(obj runtime.Object, opts ...Option) (*Result, error) {
+	h, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return h.Harden(obj)
+}
+
+// Harden returns the hardened copy of the object, which is not modified. Objects of unsupported kinds
+// are returned as they are, or rejected with ErrUnsupportedKind in strict mode
+func (h *Hardener) Harden(obj runtime.Object) (*Result, error) {
+	u, isUnstructured := obj.(*unstructured.Unstructured)
+	if isUnstructured {
+		// custom resources listed in the Workloads of the policy are hardened without conversion
+		if gvk := u.GroupVersionKind(); !generator.SupportedKind(&gvk) && generator.Supports(&gvk, h.config.policy) {
+			return h.harden(obj, obj, gvk)
+		}
+	}
+
+	typed := obj
+	if isUnstructured {
+		var err error
+		if typed, err = scheme.Scheme.New(u.GroupVersionKind()); err != nil {
+			return h.unsupported(obj, u.GetKind())
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, typed); err != nil {
+			return nil, err
+		}
+	}
+
+	gvks, _, err := scheme.Scheme.ObjectKinds(typed)
+	if err != nil {
+		return h.unsupported(obj, fmt.Sprintf("%T", obj))
+	}
+	gvk := gvks[0]
+	if !generator.SupportedKind(&gvk) {
+		return h.unsupported(obj, gvk.Kind)
+	}
+	return h.harden(obj, typed, gvk)
+}
+
+// harden hardens the typed version of the object, or the object itself if it is a custom resource.
+// The result has the type of the original object
+func (h *Hardener) harden(obj runtime.Object, typed runtime.Object, gvk schema.GroupVersionKind) (*Result, error) {
+	u, isUnstructured := obj.(*unstructured.Unstructured)
+	converted := isUnstructured && typed != obj
+
+	hardened, findings, err := generator.GenerateHardenedObject(typed, &gvk, h.config.policy)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Object: hardened}
+	if serviceAccount := generator.GenerateServiceAccount(typed, &gvk, h.config.policy); serviceAccount != nil {
+		result.ServiceAccount = serviceAccount
+	}
+	result.Changed = result.ServiceAccount != nil || !equality.Semantic.DeepEqual(typed, hardened)
+
+	if converted {
+		if result.Object, err = toUnstructured(hardened, gvk, u.Object); err != nil {
+			return nil, err
+		}
+	}
+	if isUnstructured && result.ServiceAccount != nil {
+		gvk := schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}
+		if result.ServiceAccount, err = toUnstructured(result.ServiceAccount, gvk, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range(findings) {
+		finding := Finding{Rule: f.Rule, Container: f.Container, Message: f.Message, Exempted: f.Exempted, Severity: f.Severity}
+		result.Findings = append(result.Findings, finding)
+		if h.config.onFinding != nil {
+			h.config.onFinding(obj, finding)
+		}
+	}
+	return result, nil
+}
+
+func (h *Hardener) unsupported(obj runtime.Object, kind string) (*Result, error) {
+	if h.config.strict {
+		return nil, fmt.Errorf("%w %v", ErrUnsupportedKind, kind)
+	}
+	return &Result{Object: obj.DeepCopyObject()}, nil
+}
+
+func toUnstructured(obj runtime.Object, gvk schema.GroupVersionKind, original map[string]interface{}) (*unstructured.Unstructured, error) {
+	content, err := utils.ToUnstructured(obj, original)
+	if err != nil {
+		return nil, err
+	}
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(gvk)
+	return u, nil
+}
@@ -0,0 +1,200 @@
+package harden
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNewStrictUnknownRule(t *testing.T) {
+	if _, err := New(WithStrict(true), WithoutRules("run-as-usr")); err == nil {
+		t.Fatalf("TestNewStrictUnknownRule accepted an unknown rule")
+	}
+	if _, err := New(WithoutRules("run-as-usr")); err != nil {
+		t.Fatalf("TestNewStrictUnknownRule returned %v without strict mode", err)
+	}
+}
+
+func TestNewInvalidPolicy(t *testing.T) {
+	if _, err := New(WithPolicyDocument([]byte("HostPID: maybe\n"))); err == nil {
+		t.Fatalf("TestNewInvalidPolicy accepted an invalid policy")
+	}
+	if _, err := New(WithPolicy("unknown-policy.yaml")); err == nil {
+		t.Fatalf("TestNewInvalidPolicy accepted a missing policy file")
+	}
+}
+
+func TestHardenUnsupported(t *testing.T) {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings"}}
+
+	result, err := Harden(cm)
+	if err != nil || result.Changed || result.Object.(*corev1.ConfigMap).Name != "settings" {
+		t.Fatalf("TestHardenUnsupported returned %v, %v", result, err)
+	}
+
+	if _, err := Harden(cm, WithStrict(true)); !errors.Is(err, ErrUnsupportedKind) {
+		t.Fatalf("TestHardenUnsupported returned %v in strict mode", err)
+	}
+
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("example.com/v1")
+	crd.SetKind("Widget")
+	if _, err := Harden(crd, WithStrict(true)); !errors.Is(err, ErrUnsupportedKind) {
+		t.Fatalf("TestHardenUnsupported returned %v for an unknown kind", err)
+	}
+
+	// a Deployment of another group is not an apps/v1 Deployment
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{"name": "web"},
+		"spec": map[string]interface{}{"hostNetwork": true},
+	}}
+	result, err = Harden(deployment)
+	if err != nil || result.Changed || !reflect.DeepEqual(result.Object, deployment) {
+		t.Fatalf("TestHardenUnsupported returned %v, %v for a Deployment of another group", result, err)
+	}
+}
+
+func TestHardenDoesNotModifyInput(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}, Spec: corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "web"}}}}
+
+	result, err := Harden(pod, WithPolicyDocument([]byte("Extends: restricted\nDefaultServiceAccount: false\n")))
+	if err != nil {
+		t.Fatalf("TestHardenDoesNotModifyInput returned %v", err)
+	}
+	if !pod.Spec.HostNetwork || pod.Spec.SecurityContext != nil {
+		t.Fatalf("TestHardenDoesNotModifyInput modified the input")
+	}
+	if result.ServiceAccount == nil || !result.Changed {
+		t.Fatalf("TestHardenDoesNotModifyInput did not generate a service account")
+	}
+}
+
+func TestHardenUnchanged(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "web"}}}}
+
+	result, err := Harden(pod, WithPolicy("restricted"), WithRules("host-pid"))
+	if err != nil || result.Changed || len(result.Findings) != 0 {
+		t.Fatalf("TestHardenUnchanged returned %v, %v", result, err)
+	}
+}
+
+// pinnedImageRule pins the images of the containers named pinned, so it doesn't affect the other tests
+type pinnedImageRule struct{}
+
+func (r pinnedImageRule) ID() (string) { return "test-pinned-image" }
+func (r pinnedImageRule) Description() (string) { return "Images are pinned" }
+func (r pinnedImageRule) Dependencies() ([]string) { return nil }
+func (r pinnedImageRule) Check(ps *corev1.PodSpec, ctx *Context) ([]Finding) { return r.Fix(ps.DeepCopy(), ctx) }
+func (r pinnedImageRule) Fix(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	for i, c := range(ps.Containers) {
+		if c.Name != "pinned" || ctx.ContainerExempt(r.ID(), c) != "" {
+			continue
+		}
+		ps.Containers[i].Image = "nginx:1.25"
+		output = append(output, Finding{Container: c.Name, Message: "image pinned"})
+	}
+	return output
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register(pinnedImageRule{}); err != nil {
+		t.Fatalf("TestRegister returned %v", err)
+	}
+	if err := Register(pinnedImageRule{}); err == nil {
+		t.Fatalf("TestRegister accepted a duplicated rule")
+	}
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "pinned", Image: "nginx"}}}}
+	result, err := Harden(pod, WithRules("test-pinned-image"), WithStrict(true))
+	if err != nil {
+		t.Fatalf("TestRegister returned %v", err)
+	}
+	if result.Object.(*corev1.Pod).Spec.Containers[0].Image != "nginx:1.25" || len(result.Findings) != 1 {
+		t.Fatalf("TestRegister returned %v", result)
+	}
+	if f := result.Findings[0]; f.Rule != "test-pinned-image" || f.Container != "pinned" || f.Severity != "medium" {
+		t.Fatalf("TestRegister returned the finding %v", f)
+	}
+
+	pod.Annotations = map[string]string{"manifest-hardening/exempt": "test-pinned-image"}
+	result, err = Harden(pod, WithRules("test-pinned-image"))
+	if err != nil || result.Changed {
+		t.Fatalf("TestRegister hardened an exempted pod: %v, %v", result, err)
+	}
+}
+
+// hostAliasesRule reports the host aliases of the pods with a severity, without changing them
+type hostAliasesRule struct{}
+
+func (r hostAliasesRule) ID() (string) { return "test-host-aliases" }
+func (r hostAliasesRule) Description() (string) { return "Pods don't override host names" }
+func (r hostAliasesRule) Dependencies() ([]string) { return nil }
+func (r hostAliasesRule) Check(ps *corev1.PodSpec, ctx *Context) ([]Finding) { return r.Fix(ps, ctx) }
+func (r hostAliasesRule) Fix(ps *corev1.PodSpec, ctx *Context) (output []Finding) {
+	if len(ps.HostAliases) > 0 {
+		output = append(output, Finding{Message: "host aliases are set", Severity: "high"})
+	}
+	return output
+}
+
+func TestRegisterSeverity(t *testing.T) {
+	if err := Register(hostAliasesRule{}); err != nil {
+		t.Fatalf("TestRegisterSeverity returned %v", err)
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		HostAliases: []corev1.HostAlias{{IP: "10.0.0.1", Hostnames: []string{"db"}}},
+		Containers: []corev1.Container{{Name: "web", Image: "nginx"}},
+	}}
+
+	result, err := Harden(pod, WithRules("test-host-aliases"))
+	if err != nil || len(result.Findings) != 1 || result.Findings[0].Severity != "high" {
+		t.Fatalf("TestRegisterSeverity returned %v, %v", result, err)
+	}
+
+	// the severity set in the policy overrides the one of the rule
+	result, err = Harden(pod, WithPolicyDocument([]byte("Extends: restricted\nSeverities:\n  test-host-aliases: low\n")), WithRules("test-host-aliases"))
+	if err != nil || len(result.Findings) != 1 || result.Findings[0].Severity != "low" {
+		t.Fatalf("TestRegisterSeverity returned %v, %v with a policy severity", result, err)
+	}
+}
+
+// countRule reports nothing, it is registered while other goroutines harden objects
+type countRule struct {
+	id string
+}
+
+func (r countRule) ID() (string) { return r.id }
+func (r countRule) Description() (string) { return "Registered concurrently" }
+func (r countRule) Dependencies() ([]string) { return nil }
+func (r countRule) Check(ps *corev1.PodSpec, ctx *Context) ([]Finding) { return nil }
+func (r countRule) Fix(ps *corev1.PodSpec, ctx *Context) ([]Finding) { return nil }
+
+func TestRegisterConcurrent(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}}}
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range(errs) {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i % 2 == 0 {
+				errs[i] = Register(countRule{id: fmt.Sprintf("test-concurrent-%d", i)})
+			} else {
+				_, errs[i] = Harden(pod, WithPolicyDocument([]byte("Extends: restricted\n")))
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range(errs) {
+		if err != nil {
+			t.Fatalf("TestRegisterConcurrent returned %v for goroutine %d", err, i)
+		}
+	}
+}
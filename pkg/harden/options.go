@@ -0,0 +1,79 @@
+package harden
+
+import (
+	"edurra/manifest-hardening/internal/policy"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Option configures a Hardener
+type Option func(*config) (error)
+
+// FindingFunc is called with the object being hardened and every finding of its rules
+type FindingFunc func(obj runtime.Object, f Finding)
+
+type config struct {
+	policy policy.Policy
+	enabled []string
+	disabled []string
+	strict bool
+	onFinding FindingFunc
+}
+
+// WithPolicy sets the policy, either the name of a built-in policy (restricted, baseline) or the path
+// to a policy file. The default policy is restricted
+func WithPolicy(nameOrPath string) (Option) {
+	return func(c *config) (error) {
+		pol, err := policy.Resolve(nameOrPath)
+		if err != nil {
+			return err
+		}
+		c.policy = pol
+		return nil
+	}
+}
+
+// WithPolicyDocument sets the policy from the content of a policy file. Relative paths in Extends
+// are resolved from the working directory
+func WithPolicyDocument(data []byte) (Option) {
+	return func(c *config) (error) {
+		pol, err := policy.Parse(data)
+		if err != nil {
+			return err
+		}
+		c.policy = pol
+		return nil
+	}
+}
+
+// WithRules only evaluates the given rules, replacing the EnabledRules of the policy
+func WithRules(ids ...string) (Option) {
+	return func(c *config) (error) {
+		c.enabled = ids
+		return nil
+	}
+}
+
+// WithoutRules never evaluates the given rules, in addition to the DisabledRules of the policy
+func WithoutRules(ids ...string) (Option) {
+	return func(c *config) (error) {
+		c.disabled = append(c.disabled, ids...)
+		return nil
+	}
+}
+
+// WithStrict rejects what is otherwise ignored: objects of unsupported kinds and unknown rule IDs
+// in WithRules and WithoutRules
+func WithStrict(strict bool) (Option) {
+	return func(c *config) (error) {
+		c.strict = strict
+		return nil
+	}
+}
+
+// WithFindingCallback calls fn for every finding, as objects are hardened
+func WithFindingCallback(fn FindingFunc) (Option) {
+	return func(c *config) (error) {
+		c.onFinding = fn
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package harden
+
+import (
+	"edurra/manifest-hardening/internal/generator"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Rule is a security control evaluated against the pod spec of every hardened object, after the
+// built-in rules it depends on. Rules are added with Register, usually from an init function
+type Rule interface {
+	// ID is the name used in policies, exemptions and findings, e.g. "no-latest-tag"
+	ID() string
+	Description() string
+	// Dependencies returns the IDs of the rules that must be evaluated before this one
+	Dependencies() []string
+	// Check returns the findings of the rule without modifying the pod spec
+	Check(ps *corev1.PodSpec, ctx *Context) []Finding
+	// Fix remediates the pod spec and returns what was changed
+	Fix(ps *corev1.PodSpec, ctx *Context) []Finding
+}
+
+// Context is what a rule knows about the workload whose pod spec is evaluated
+type Context struct {
+	// Meta is the metadata of the workload, including the annotations of its pod template
+	Meta metav1.ObjectMeta
+	// User is the non-root user assigned to the pod and its containers when the policy enforces RunAsUser
+	User int64
+	ctx *generator.Context
+}
+
+// PodExempt returns the source of the exemption of a pod level rule, or "" if the rule applies
+func (c *Context) PodExempt(rule string) (string) {
+	return c.ctx.PodExempt(rule)
+}
+
+// ContainerExempt returns the source of the exemption of a container level rule, or "" if the rule applies
+func (c *Context) ContainerExempt(rule string, container corev1.Container) (string) {
+	return c.ctx.ContainerExempt(rule, container)
+}
+
+// Register adds a rule to the ones evaluated by every Hardener. Its ID is accepted in WithRules, WithoutRules
+// and in the EnabledRules, DisabledRules, Severities and exemptions of policies. IDs must be unique.
+// The severity of its findings is set by the Severities of the policy, or by the rule in the Severity of
+// each finding, medium by default. Register can be called concurrently with Harden, and the rule is
+// evaluated by the objects hardened after it returns
+func Register(r Rule) (error) {
+	return generator.Register(registeredRule{r})
+}
+
+// registeredRule adapts a Rule to the rules of the generator
+type registeredRule struct {
+	Rule
+}
+
+func (r registeredRule) Check(ps *corev1.PodSpec, ctx *generator.Context) ([]generator.Finding) {
+	return r.findings(r.Rule.Check(ps, newContext(ctx)))
+}
+
+func (r registeredRule) Fix(ps *corev1.PodSpec, ctx *generator.Context) ([]generator.Finding) {
+	return r.findings(r.Rule.Fix(ps, newContext(ctx)))
+}
+
+// findings converts the findings of the rule, whose Rule defaults to its ID
+func (r registeredRule) findings(findings []Finding) ([]generator.Finding) {
+	var result []generator.Finding
+	for _, f := range(findings) {
+		if f.Rule == "" {
+			f.Rule = r.ID()
+		}
+		result = append(result, generator.Finding{Rule: f.Rule, Container: f.Container, Message: f.Message, Exempted: f.Exempted, Severity: f.Severity})
+	}
+	return result
+}
+
+func newContext(ctx *generator.Context) (*Context) {
+	return &Context{Meta: ctx.Meta, User: ctx.User, ctx: ctx}
+}